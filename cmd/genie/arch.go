@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/mewmew/genie/ctype"
+	"github.com/pkg/errors"
+)
+
+// arch is the machine architecture of an original binary executable, which
+// determines the jmp instruction injected to hook functions.
+type arch struct {
+	// Machine architecture of original binary executable.
+	Machine machine
+	// Original binary executable targets Windows (PE), as opposed to Unix
+	// (ELF); determines the calling convention of x86-64 functions.
+	Windows bool
+	// Size of injected jmp instruction in number of bytes. The patch is rounded
+	// up to whole instructions of the original function.
+	//
+	// On x86, a 5-byte relative jmp is used:
+	//
+	//    E9 XX XX XX XX       jmp rel32
+	//
+	// On x86-64, the hook may be located more than 2 GB away, so a 14-byte
+	// absolute jmp is used, which (unlike the 12-byte "mov rax, imm64; jmp rax"
+	// sequence) leaves all registers intact:
+	//
+	//    FF 25 00 00 00 00    jmp qword ptr [rip+0]
+	//    XX XX XX XX XX XX XX XX    ; 64-bit absolute address of hook
+	JmpSize int64
+	// Number of hexadecimal digits used to print addresses.
+	AddrDigits int
+}
+
+// archOf returns the machine architecture of the given original binary
+// executable.
+func archOf(exe executable) (*arch, error) {
+	_, windows := exe.(*peExecutable)
+	switch m := exe.Machine(); m {
+	case machine386:
+		return &arch{Machine: m, Windows: windows, JmpSize: 5, AddrDigits: 6}, nil
+	case machineAMD64:
+		return &arch{Machine: m, Windows: windows, JmpSize: 14, AddrDigits: 16}, nil
+	default:
+		return nil, errors.Errorf("support for machine architecture %v not yet implemented", m)
+	}
+}
+
+// is64 reports whether the architecture is x86-64.
+func (a *arch) is64() bool {
+	return a.Machine == machineAMD64
+}
+
+// jmp returns the machine code of the jmp instruction injected at the given
+// address, to jump to the specified target address.
+func (a *arch) jmp(addr, target uint64) []byte {
+	if a.is64() {
+		// jmp qword ptr [rip+0]
+		buf := []byte{0xFF, 0x25, 0x00, 0x00, 0x00, 0x00, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint64(buf[6:], target)
+		return buf
+	}
+	// jmp rel32
+	buf := []byte{0xE9, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(buf[1:], uint32(target-(addr+5)))
+	return buf
+}
+
+// mode returns the processor mode of the architecture in number of bits.
+func (a *arch) mode() int {
+	if a.is64() {
+		return 64
+	}
+	return 32
+}
+
+// addrString returns the C hexadecimal literal of the given address.
+func (a *arch) addrString(addr uint64) string {
+	return fmt.Sprintf("0x%0*X", a.AddrDigits, addr)
+}
+
+// callConv returns the calling convention of hooks on the architecture, given
+// the calling convention of the original function.
+//
+// On x86-64, the 32-bit calling conventions (__cdecl, __stdcall, __fastcall,
+// __thiscall and regparm) are ignored by compilers, and functions use the
+// Win64 calling convention on Windows and the System V calling convention on
+// Unix. As hooks may be compiled for other targets, the calling convention of
+// the platform is specified explicitly unless the calling convention of the
+// other platform or __vectorcall is used.
+func (a *arch) callConv(cc ctype.CallingConv) ctype.CallingConv {
+	if !a.is64() {
+		return cc
+	}
+	switch cc {
+	case ctype.CallConvSysVABI, ctype.CallConvMSABI, ctype.CallConvVectorCall:
+		return cc
+	}
+	if a.Windows {
+		return ctype.CallConvMSABI
+	}
+	return ctype.CallConvSysVABI
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"debug/elf"
+	"testing"
+
+	"github.com/mewmew/genie/ctype"
+	"github.com/mewmew/pe"
+	peenum "github.com/mewmew/pe/enum"
+)
+
+func TestArchOf(t *testing.T) {
+	golden := []struct {
+		name    string
+		exe     executable
+		windows bool
+		jmpSize int64
+		addr    string
+	}{
+		{name: "PE x86", exe: &peExecutable{machine: machine386}, windows: true, jmpSize: 5, addr: "0x401000"},
+		{name: "PE x86-64", exe: &peExecutable{machine: machineAMD64}, windows: true, jmpSize: 14, addr: "0x0000000000401000"},
+		{name: "ELF x86", exe: &elfExecutable{machine: machine386}, jmpSize: 5, addr: "0x401000"},
+		{name: "ELF x86-64", exe: &elfExecutable{machine: machineAMD64}, jmpSize: 14, addr: "0x0000000000401000"},
+	}
+	for _, g := range golden {
+		a, err := archOf(g.exe)
+		if err != nil {
+			t.Errorf("%s: %+v", g.name, err)
+			continue
+		}
+		if a.Windows != g.windows {
+			t.Errorf("%s: Windows mismatch; expected %v, got %v", g.name, g.windows, a.Windows)
+		}
+		if a.JmpSize != g.jmpSize {
+			t.Errorf("%s: jmp size mismatch; expected %d, got %d", g.name, g.jmpSize, a.JmpSize)
+		}
+		if got := a.addrString(0x401000); got != g.addr {
+			t.Errorf("%s: address mismatch; expected %q, got %q", g.name, g.addr, got)
+		}
+	}
+	if _, err := archOf(&elfExecutable{}); err == nil {
+		t.Errorf("unknown machine: expected error, got nil")
+	}
+}
+
+func TestNewPEExecutable(t *testing.T) {
+	golden := []struct {
+		machine peenum.MachineType
+		magic   uint16
+		want    machine
+	}{
+		{machine: peenum.MachineTypeI386, magic: 0x10B, want: machine386},
+		{machine: peenum.MachineTypeAMD64, magic: pe32PlusMagic, want: machineAMD64},
+		// Optional header mismatch.
+		{machine: peenum.MachineTypeI386, magic: pe32PlusMagic},
+		{machine: peenum.MachineTypeAMD64, magic: 0x10B},
+		// Unsupported machine type.
+		{machine: peenum.MachineTypeARM, magic: 0x10B},
+	}
+	for _, g := range golden {
+		file := &pe.File{
+			FileHdr: &pe.FileHeader{Machine: g.machine},
+			OptHdr:  &pe.OptHeader{Magic: g.magic},
+		}
+		exe, err := newPEExecutable(file)
+		if g.want == 0 {
+			if err == nil {
+				t.Errorf("%v: expected error, got nil", g.machine)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %+v", g.machine, err)
+			continue
+		}
+		if got := exe.Machine(); got != g.want {
+			t.Errorf("%v: machine mismatch; expected %v, got %v", g.machine, g.want, got)
+		}
+	}
+}
+
+func TestNewELFExecutable(t *testing.T) {
+	golden := []struct {
+		name  string
+		file  *elf.File
+		want  machine
+		base  uint64
+		sects []string
+	}{
+		{
+			name: "x86-64 executable",
+			file: &elf.File{
+				FileHeader: elf.FileHeader{Class: elf.ELFCLASS64, Machine: elf.EM_X86_64},
+				Progs: []*elf.Prog{
+					{ProgHeader: elf.ProgHeader{Type: elf.PT_PHDR, Vaddr: 0x400040}},
+					{ProgHeader: elf.ProgHeader{Type: elf.PT_LOAD, Vaddr: 0x401000, Align: 0x1000}},
+					{ProgHeader: elf.ProgHeader{Type: elf.PT_LOAD, Vaddr: 0x400000, Align: 0x1000}},
+				},
+				Sections: []*elf.Section{
+					{SectionHeader: elf.SectionHeader{Name: ".comment"}},
+					{SectionHeader: elf.SectionHeader{Name: ".bss", Type: elf.SHT_NOBITS, Flags: elf.SHF_ALLOC | elf.SHF_WRITE, Addr: 0x404000, Size: 0x10}},
+				},
+			},
+			want:  machineAMD64,
+			base:  0x400000,
+			sects: []string{".bss"},
+		},
+		{
+			name: "x86 position independent executable",
+			file: &elf.File{
+				FileHeader: elf.FileHeader{Class: elf.ELFCLASS32, Machine: elf.EM_386},
+				Progs: []*elf.Prog{
+					{ProgHeader: elf.ProgHeader{Type: elf.PT_LOAD, Vaddr: 0, Align: 0x1000}},
+				},
+			},
+			want: machine386,
+			base: 0,
+		},
+	}
+	for _, g := range golden {
+		exe, err := newELFExecutable(g.file)
+		if err != nil {
+			t.Errorf("%s: %+v", g.name, err)
+			continue
+		}
+		if got := exe.Machine(); got != g.want {
+			t.Errorf("%s: machine mismatch; expected %v, got %v", g.name, g.want, got)
+		}
+		if got := exe.ImageBase(); got != g.base {
+			t.Errorf("%s: image base mismatch; expected 0x%X, got 0x%X", g.name, g.base, got)
+		}
+		var sects []string
+		for _, sect := range exe.Sections() {
+			sects = append(sects, sect.Name)
+		}
+		if len(sects) != len(g.sects) || (len(sects) > 0 && sects[0] != g.sects[0]) {
+			t.Errorf("%s: sections mismatch; expected %q, got %q", g.name, g.sects, sects)
+		}
+	}
+	arm := &elf.File{FileHeader: elf.FileHeader{Machine: elf.EM_ARM}}
+	if _, err := newELFExecutable(arm); err == nil {
+		t.Errorf("ARM: expected error, got nil")
+	}
+}
+
+func TestReadSections(t *testing.T) {
+	sects := []*section{
+		{Name: ".text", Addr: 0x401000, Size: 0x10, Data: []byte{0x55, 0x89, 0xE5, 0xC3}},
+		{Name: ".bss", Addr: 0x402000, Size: 0x10},
+	}
+	golden := []struct {
+		addr uint64
+		n    int64
+		want []byte
+	}{
+		{addr: 0x401000, n: 2, want: []byte{0x55, 0x89}},
+		{addr: 0x401001, n: 3, want: []byte{0x89, 0xE5, 0xC3}},
+		// Past the end of the stored contents.
+		{addr: 0x401002, n: 3},
+		// Uninitialized data.
+		{addr: 0x402000, n: 1},
+	}
+	for _, g := range golden {
+		got, err := readSections(sects, g.addr, g.n)
+		if g.want == nil {
+			if err == nil {
+				t.Errorf("0x%X: expected error, got nil", g.addr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("0x%X: %+v", g.addr, err)
+			continue
+		}
+		if string(got) != string(g.want) {
+			t.Errorf("0x%X: contents mismatch; expected % X, got % X", g.addr, g.want, got)
+		}
+	}
+}
+
+func TestArchCallConv(t *testing.T) {
+	x86 := &arch{Machine: machine386, Windows: true}
+	x64Windows := &arch{Machine: machineAMD64, Windows: true}
+	x64 := &arch{Machine: machineAMD64}
+	golden := []struct {
+		name string
+		a    *arch
+		cc   ctype.CallingConv
+		want ctype.CallingConv
+	}{
+		{name: "x86", a: x86, cc: 0, want: 0},
+		{name: "x86", a: x86, cc: ctype.CallConvStdCall, want: ctype.CallConvStdCall},
+		{name: "x86", a: x86, cc: ctype.CallConvRegParm2, want: ctype.CallConvRegParm2},
+		{name: "x86-64 Windows", a: x64Windows, cc: 0, want: ctype.CallConvMSABI},
+		{name: "x86-64 Windows", a: x64Windows, cc: ctype.CallConvStdCall, want: ctype.CallConvMSABI},
+		{name: "x86-64 Windows", a: x64Windows, cc: ctype.CallConvVectorCall, want: ctype.CallConvVectorCall},
+		{name: "x86-64 Windows", a: x64Windows, cc: ctype.CallConvSysVABI, want: ctype.CallConvSysVABI},
+		{name: "x86-64 Unix", a: x64, cc: 0, want: ctype.CallConvSysVABI},
+		{name: "x86-64 Unix", a: x64, cc: ctype.CallConvFastCall, want: ctype.CallConvSysVABI},
+		{name: "x86-64 Unix", a: x64, cc: ctype.CallConvMSABI, want: ctype.CallConvMSABI},
+	}
+	for _, g := range golden {
+		if got := g.a.callConv(g.cc); got != g.want {
+			t.Errorf("%s: calling convention mismatch of %v; expected %v, got %v", g.name, g.cc, g.want, got)
+		}
+	}
+}
+
+func TestArchJmp(t *testing.T) {
+	golden := []struct {
+		a            *arch
+		addr, target uint64
+		want         []byte
+	}{
+		{
+			a:    &arch{Machine: machine386},
+			addr: 0x401000, target: 0x10001000,
+			want: []byte{0xE9, 0xFB, 0xFF, 0xBF, 0x0F},
+		},
+		// Backward jmp.
+		{
+			a:    &arch{Machine: machine386},
+			addr: 0x401000, target: 0x400000,
+			want: []byte{0xE9, 0xFB, 0xEF, 0xFF, 0xFF},
+		},
+		{
+			a:    &arch{Machine: machineAMD64},
+			addr: 0x401000, target: 0x7FF712345678,
+			want: []byte{0xFF, 0x25, 0x00, 0x00, 0x00, 0x00, 0x78, 0x56, 0x34, 0x12, 0xF7, 0x7F, 0x00, 0x00},
+		},
+	}
+	for _, g := range golden {
+		if got := g.a.jmp(g.addr, g.target); string(got) != string(g.want) {
+			t.Errorf("%v: jmp mismatch of 0x%X; expected % X, got % X", g.a.Machine, g.target, g.want, got)
+		}
+	}
+}
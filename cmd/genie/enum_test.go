@@ -0,0 +1,184 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mewmew/genie/ctype"
+)
+
+func TestCollectEnumsFlagEnums(t *testing.T) {
+	// typedef enum { GFLAG_A = 1, GFLAG_B = 2 } gflags_t;
+	anon := &ctype.EnumType{
+		Enumerators: []*ctype.Enumerator{
+			{Name: "GFLAG_A", Value: 1},
+			{Name: "GFLAG_B", Value: 2},
+		},
+	}
+	gflags := &ctype.Typedef{Name: "gflags_t", Typ: anon}
+	// typedef enum color { RED, GREEN } color_t;
+	color := &ctype.EnumType{
+		Name: "color",
+		Enumerators: []*ctype.Enumerator{
+			{Name: "RED", Value: 0},
+			{Name: "GREEN", Value: 1},
+		},
+	}
+	colorT := &ctype.Typedef{Name: "color_t", Typ: color}
+	// struct s { const gflags_t flags; color_t c; };
+	s := &ctype.StructType{
+		Name: "s",
+		Fields: []*ctype.Field{
+			{Name: "flags", Typ: &ctype.ConstType{Typ: gflags}},
+			{Name: "c", Typ: colorT},
+		},
+	}
+	golden := []struct {
+		flagEnums []string
+		want      []bool
+	}{
+		{flagEnums: nil, want: []bool{false, false}},
+		{flagEnums: []string{"gflags_t"}, want: []bool{true, false}},
+		{flagEnums: []string{"color"}, want: []bool{false, true}},
+		{flagEnums: []string{"color_t"}, want: []bool{false, true}},
+	}
+	for _, g := range golden {
+		gen := newHookGen()
+		for _, name := range g.flagEnums {
+			gen.flagEnums[name] = true
+		}
+		enums := collectEnums(s, nil)
+		if len(enums) != len(g.want) {
+			t.Fatalf("collectEnums: expected %d enums, got %d", len(g.want), len(enums))
+		}
+		for i, e := range enums {
+			if got := gen.isFlagEnum(e); got != g.want[i] {
+				t.Errorf("isFlagEnum(%v) with -flagenums %v: expected %v, got %v", e.typedefs, g.flagEnums, g.want[i], got)
+			}
+		}
+	}
+}
+
+func TestNewEnumPrinter(t *testing.T) {
+	golden := []struct {
+		typ   *ctype.EnumType
+		flags bool
+		// Expected names of switch cases, and of zero enumerator.
+		cases []string
+		zero  string
+		mask  int64
+	}{
+		// enum color { RED, GREEN, GRUEN = GREEN };
+		{
+			typ: &ctype.EnumType{
+				Name: "color",
+				Enumerators: []*ctype.Enumerator{
+					{Name: "RED", Value: 0},
+					{Name: "GREEN", Value: 1},
+					{Name: "GRUEN", Value: 1},
+				},
+			},
+			cases: []string{"RED", "GREEN"},
+			mask:  0xFFFFFFFF,
+		},
+		// enum mode { NONE, R = 1, W = 2, RW = 3, NEG = -4 };
+		{
+			typ: &ctype.EnumType{
+				Name: "mode",
+				Enumerators: []*ctype.Enumerator{
+					{Name: "NONE", Value: 0},
+					{Name: "R", Value: 1},
+					{Name: "W", Value: 2},
+					{Name: "RW", Value: 3},
+					{Name: "NEG", Value: -4},
+				},
+			},
+			flags: true,
+			cases: []string{"NEG", "RW", "R", "W"},
+			zero:  "NONE",
+			mask:  0xFFFFFFFF,
+		},
+		// enum big { SMALL = -1, LARGE = 0x100000000 };
+		{
+			typ: &ctype.EnumType{
+				Name: "big",
+				Enumerators: []*ctype.Enumerator{
+					{Name: "SMALL", Value: -1},
+					{Name: "LARGE", Value: 0x100000000},
+				},
+			},
+			flags: true,
+			cases: []string{"SMALL", "LARGE"},
+		},
+	}
+	for _, g := range golden {
+		p := newEnumPrinter(g.typ, g.flags)
+		if p.Flags != g.flags {
+			t.Errorf("%s: flags mismatch; expected %v, got %v", p.Name, g.flags, p.Flags)
+		}
+		if g.typ.Flags {
+			t.Errorf("%s: enum type modified by print helper", p.Name)
+		}
+		var cases []string
+		for _, c := range p.Cases {
+			cases = append(cases, c.Name)
+		}
+		if !reflect.DeepEqual(cases, g.cases) {
+			t.Errorf("%s: cases mismatch; expected %v, got %v", p.Name, g.cases, cases)
+		}
+		var zero string
+		if p.Zero != nil {
+			zero = p.Zero.Name
+		}
+		if zero != g.zero {
+			t.Errorf("%s: zero enumerator mismatch; expected %q, got %q", p.Name, g.zero, zero)
+		}
+		if p.Mask != g.mask {
+			t.Errorf("%s: mask mismatch; expected 0x%X, got 0x%X", p.Name, g.mask, p.Mask)
+		}
+	}
+}
+
+func TestEnumPrinterName(t *testing.T) {
+	golden := []struct {
+		typ  *ctype.EnumType
+		want string
+	}{
+		{typ: &ctype.EnumType{Name: "color"}, want: "print_enum_color_genie"},
+		// Anonymous enums are identified by their first enumerator.
+		{
+			typ:  &ctype.EnumType{Enumerators: []*ctype.Enumerator{{Name: "GFLAG_A", Value: 1}}},
+			want: "print_enum_GFLAG_A_genie",
+		},
+	}
+	for _, g := range golden {
+		if got := enumPrinterName(g.typ); got != g.want {
+			t.Errorf("print helper name mismatch; expected %q, got %q", g.want, got)
+		}
+	}
+}
+
+func TestCollectEnums(t *testing.T) {
+	color := &ctype.EnumType{
+		Name:        "color",
+		Enumerators: []*ctype.Enumerator{{Name: "RED", Value: 0}},
+	}
+	opaque := &ctype.EnumType{Name: "opaque"}
+	// struct s { const color_t c; enum opaque o; int x; enum color *p; };
+	s := &ctype.StructType{
+		Name: "s",
+		Fields: []*ctype.Field{
+			{Name: "c", Typ: &ctype.ConstType{Typ: &ctype.Typedef{Name: "color_t", Typ: color}}},
+			{Name: "o", Typ: opaque},
+			{Name: "x", Typ: ctype.BasicTypeInt},
+			{Name: "p", Typ: &ctype.PointerType{Elem: color}},
+		},
+	}
+	enums := collectEnums(s, nil)
+	if len(enums) != 1 || enums[0].typ != color {
+		t.Fatalf("enums mismatch; expected [%v], got %d enums", color, len(enums))
+	}
+	if !reflect.DeepEqual(enums[0].typedefs, []string{"color_t"}) {
+		t.Errorf("type definitions mismatch; expected [color_t], got %v", enums[0].typedefs)
+	}
+}
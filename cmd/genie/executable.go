@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io/ioutil"
+	"strings"
+
+	"github.com/mewmew/pe"
+	peenum "github.com/mewmew/pe/enum"
+	"github.com/pkg/errors"
+)
+
+// executable is an original binary executable.
+type executable interface {
+	// Machine returns the machine architecture of the executable.
+	Machine() machine
+	// ImageBase returns the preferred base address of the executable image.
+	ImageBase() uint64
+	// Sections returns the sections of the executable, as loaded into memory.
+	Sections() []*section
+	// ReadAt returns the n bytes at the given virtual address.
+	ReadAt(addr uint64, n int64) ([]byte, error)
+	// Relocs returns the virtual addresses of absolute addresses relocated by
+	// the loader when the image is loaded at a base address other than its
+	// preferred one.
+	Relocs() []uint64
+	// Lookup returns the virtual address of the function exported by the
+	// executable with the given symbol name.
+	Lookup(sym string) (uint64, error)
+	// ImportSlot returns the virtual address of the import address table slot
+	// of the given imported function.
+	ImportSlot(imp *importRef) (uint64, error)
+}
+
+// machine is the machine architecture of an executable.
+type machine uint8
+
+// Machine architectures.
+const (
+	machine386 machine = iota + 1
+	machineAMD64
+)
+
+// String returns the name of the machine architecture.
+func (m machine) String() string {
+	switch m {
+	case machine386:
+		return "x86"
+	case machineAMD64:
+		return "x86-64"
+	default:
+		return "unknown machine"
+	}
+}
+
+// section is a section of an executable.
+type section struct {
+	// Section name.
+	Name string
+	// Virtual address of section.
+	Addr uint64
+	// Size of section in memory.
+	Size uint64
+	// Contents of section stored in the file; may be shorter than the section
+	// in memory (e.g. uninitialized data).
+	Data []byte
+	// Section contains executable code.
+	Exec bool
+}
+
+// openExecutable parses the given PE or ELF executable, as identified by its
+// magic number.
+func openExecutable(path string) (executable, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	switch {
+	case bytes.HasPrefix(buf, []byte("MZ")):
+		file, err := parsePE(buf)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse PE file %q", path)
+		}
+		return newPEExecutable(file)
+	case bytes.HasPrefix(buf, []byte(elf.ELFMAG)):
+		file, err := elf.NewFile(bytes.NewReader(buf))
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse ELF file %q", path)
+		}
+		return newELFExecutable(file)
+	default:
+		return nil, errors.Errorf("unknown file format of %q; expected PE or ELF executable", path)
+	}
+}
+
+// readSections returns the n bytes at the given virtual address, as stored in
+// the given sections.
+func readSections(sects []*section, addr uint64, n int64) ([]byte, error) {
+	for _, sect := range sects {
+		end := sect.Addr + uint64(len(sect.Data))
+		if sect.Addr <= addr && addr+uint64(n) <= end {
+			offset := addr - sect.Addr
+			return sect.Data[offset : offset+uint64(n)], nil
+		}
+	}
+	return nil, errors.Errorf("unable to read %d bytes at address 0x%X; not contained within a section", n, addr)
+}
+
+// --- [ PE ] ------------------------------------------------------------------
+
+// Magic number of PE32+ (64-bit) optional headers.
+const pe32PlusMagic = 0x20B
+
+// unparsedDataDirs specifies the indices of data directories with contents not
+// yet supported by github.com/mewmew/pe (which panics on encountering them),
+// and not required by genie beyond the data directory entry.
+var unparsedDataDirs = []int{0, 3, 4, 7, 8, 9, 10, 11, 13, 14}
+
+// parsePE parses the given PE file contents. The contents of data directories
+// not yet supported by github.com/mewmew/pe (e.g. the export table) are not
+// parsed, but their data directory entries are retained.
+func parsePE(buf []byte) (*pe.File, error) {
+	hdr, err := parseHeaders(buf)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// Hide unsupported data directories while parsing.
+	hidden := append([]byte(nil), buf...)
+	for _, idx := range unparsedDataDirs {
+		hdr.setDataDir(hidden, idx, 0, 0)
+	}
+	file, err := pe.ParseBytes(hidden)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	file.Content = buf
+	for idx := range file.DataDirs {
+		if idx >= hdr.ndataDirs {
+			break
+		}
+		off := hdr.dataDirs + 8*idx
+		file.DataDirs[idx] = pe.DataDirectory{
+			RelAddr: binary.LittleEndian.Uint32(buf[off:]),
+			Size:    binary.LittleEndian.Uint32(buf[off+4:]),
+		}
+	}
+	return file, nil
+}
+
+// peExecutable is a PE executable.
+type peExecutable struct {
+	// Parsed PE file.
+	file *pe.File
+	// Machine architecture.
+	machine machine
+	// Sections, as loaded into memory.
+	sects []*section
+	// Virtual addresses of base relocations.
+	relocs []uint64
+	// Export table; parsed on first use.
+	exports *peExports
+}
+
+// newPEExecutable returns a new executable for the given PE file.
+func newPEExecutable(file *pe.File) (*peExecutable, error) {
+	exe := &peExecutable{file: file}
+	pe32Plus := file.OptHdr.Magic == pe32PlusMagic
+	switch m := file.FileHdr.Machine; m {
+	case peenum.MachineTypeI386:
+		if pe32Plus {
+			return nil, errors.Errorf("invalid PE32+ optional header of %v executable", m)
+		}
+		exe.machine = machine386
+	case peenum.MachineTypeAMD64:
+		if !pe32Plus {
+			return nil, errors.Errorf("invalid PE32 optional header of %v executable", m)
+		}
+		exe.machine = machineAMD64
+	default:
+		return nil, errors.Errorf("support for machine type %v not yet implemented", m)
+	}
+	for _, sectHdr := range file.SectHdrs {
+		start := uint64(sectHdr.DataOffset)
+		end := start + uint64(sectHdr.DataSize)
+		if end > uint64(len(file.Content)) {
+			return nil, errors.Errorf("contents of section %q extends past end of file", sectHdr.Name)
+		}
+		sect := &section{
+			Name: sectHdr.Name,
+			Addr: file.OptHdr.ImageBase + uint64(sectHdr.RelAddr),
+			Size: uint64(sectHdr.VirtualSize),
+			Data: file.Content[start:end],
+			Exec: sectHdr.Flags&peenum.SectionFlagMemExecute != 0,
+		}
+		exe.sects = append(exe.sects, sect)
+	}
+	for _, block := range file.BaseRelocBlocks {
+		for _, entry := range block.Entries {
+			// Absolute base relocations are used for padding.
+			if entry.Type == peenum.BaseRelocTypeAbsolute {
+				continue
+			}
+			addr := file.OptHdr.ImageBase + uint64(block.PageRelAddr) + uint64(entry.Offset)
+			exe.relocs = append(exe.relocs, addr)
+		}
+	}
+	return exe, nil
+}
+
+// Machine returns the machine architecture of the executable.
+func (exe *peExecutable) Machine() machine {
+	return exe.machine
+}
+
+// ImageBase returns the preferred base address of the executable image.
+func (exe *peExecutable) ImageBase() uint64 {
+	return exe.file.OptHdr.ImageBase
+}
+
+// Sections returns the sections of the executable, as loaded into memory.
+func (exe *peExecutable) Sections() []*section {
+	return exe.sects
+}
+
+// ReadAt returns the n bytes at the given virtual address.
+func (exe *peExecutable) ReadAt(addr uint64, n int64) ([]byte, error) {
+	return readSections(exe.sects, addr, n)
+}
+
+// Relocs returns the virtual addresses of absolute addresses relocated by the
+// loader when the image is loaded at a base address other than its preferred
+// one.
+func (exe *peExecutable) Relocs() []uint64 {
+	return exe.relocs
+}
+
+// Lookup returns the virtual address of the function exported by the
+// executable with the given symbol name; either an export name or an ordinal
+// of the form "#N".
+func (exe *peExecutable) Lookup(sym string) (uint64, error) {
+	if exe.exports == nil {
+		var exportDir pe.DataDirectory
+		if len(exe.file.DataDirs) > dataDirExport {
+			exportDir = exe.file.DataDirs[dataDirExport]
+		}
+		exports, err := parsePEExports(exe, exportDir.RelAddr, exportDir.Size)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		exe.exports = exports
+	}
+	addr, err := exe.exports.lookupSym(sym)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return exe.ImageBase() + uint64(addr), nil
+}
+
+// ImportSlot returns the virtual address of the import address table slot of
+// the given imported function. All import descriptors of the DLL are searched,
+// as linkers may emit several descriptors for the same DLL (e.g. when merging
+// import libraries).
+func (exe *peExecutable) ImportSlot(imp *importRef) (uint64, error) {
+	ptrSize := uint64(4)
+	if exe.machine == machineAMD64 {
+		ptrSize = 8
+	}
+	ordinal, isOrdinal := parseOrdinal(imp.Sym)
+	found := false
+	for _, entry := range exe.file.Imps {
+		if !strings.EqualFold(entry.ImpDir.Name, imp.DLL) {
+			continue
+		}
+		found = true
+		// The import address table is identical to the import name table prior
+		// to binding; the import name table may be omitted.
+		ents := entry.INTs
+		if len(ents) == 0 {
+			ents = entry.IATs
+		}
+		for i, ent := range ents {
+			match := ent.NameEntry.Name == imp.Sym
+			if ent.IsOrdinal {
+				match = isOrdinal && uint32(ent.Ordinal) == ordinal
+			}
+			if match {
+				return exe.ImageBase() + uint64(entry.ImpDir.IATRelAddr) + uint64(i)*ptrSize, nil
+			}
+		}
+	}
+	if found {
+		return 0, errors.Errorf("unable to locate import %q of %q", imp.Sym, imp.DLL)
+	}
+	return 0, errors.Errorf("unable to locate imports of %q", imp.DLL)
+}
+
+// uint32At returns the 32-bit value at the given relative address of the
+// executable.
+func (exe *peExecutable) uint32At(addr uint32) (uint32, bool) {
+	buf, err := exe.ReadAt(exe.ImageBase()+uint64(addr), 4)
+	if err != nil {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(buf), true
+}
+
+// uint16At returns the 16-bit value at the given relative address of the
+// executable.
+func (exe *peExecutable) uint16At(addr uint32) (uint16, bool) {
+	buf, err := exe.ReadAt(exe.ImageBase()+uint64(addr), 2)
+	if err != nil {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint16(buf), true
+}
+
+// cString returns the NULL-terminated string at the given relative address of
+// the executable.
+func (exe *peExecutable) cString(addr uint32) string {
+	va := exe.ImageBase() + uint64(addr)
+	sect := sectionOf(exe, va)
+	if sect == nil {
+		return ""
+	}
+	buf := sect.Data[va-sect.Addr:]
+	if pos := bytes.IndexByte(buf, 0); pos != -1 {
+		buf = buf[:pos]
+	}
+	return string(buf)
+}
+
+// --- [ ELF ] -----------------------------------------------------------------
+
+// elfExecutable is an ELF executable.
+type elfExecutable struct {
+	// Machine architecture.
+	machine machine
+	// Preferred base address of the executable image; zero for position
+	// independent executables.
+	imageBase uint64
+	// Sections, as loaded into memory.
+	sects []*section
+	// Virtual addresses of dynamic relocations.
+	relocs []uint64
+	// Virtual addresses of functions defined in the dynamic symbol table, by
+	// symbol name.
+	dynsyms map[string]uint64
+}
+
+// newELFExecutable returns a new executable for the given ELF file.
+func newELFExecutable(file *elf.File) (*elfExecutable, error) {
+	exe := &elfExecutable{
+		dynsyms: make(map[string]uint64),
+	}
+	switch file.Machine {
+	case elf.EM_386:
+		exe.machine = machine386
+	case elf.EM_X86_64:
+		exe.machine = machineAMD64
+	default:
+		return nil, errors.Errorf("support for machine type %v not yet implemented", file.Machine)
+	}
+	// The image base is the page aligned address of the first loadable
+	// segment.
+	first := true
+	for _, prog := range file.Progs {
+		if prog.Type != elf.PT_LOAD {
+			continue
+		}
+		base := prog.Vaddr
+		if prog.Align > 1 {
+			base &^= prog.Align - 1
+		}
+		if first || base < exe.imageBase {
+			exe.imageBase = base
+			first = false
+		}
+	}
+	for _, s := range file.Sections {
+		if s.Flags&elf.SHF_ALLOC == 0 {
+			continue
+		}
+		sect := &section{
+			Name: s.Name,
+			Addr: s.Addr,
+			Size: s.Size,
+			Exec: s.Flags&elf.SHF_EXECINSTR != 0,
+		}
+		if s.Type != elf.SHT_NOBITS {
+			data, err := s.Data()
+			if err != nil {
+				return nil, errors.Wrapf(err, "unable to read contents of section %q", s.Name)
+			}
+			sect.Data = data
+		}
+		exe.sects = append(exe.sects, sect)
+		if s.Type == elf.SHT_REL || s.Type == elf.SHT_RELA {
+			relocs, err := elfRelocs(file, s)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			exe.relocs = append(exe.relocs, relocs...)
+		}
+	}
+	// Executables without dynamic symbols (e.g. statically linked) have no
+	// .dynsym section.
+	syms, err := file.DynamicSymbols()
+	if err != nil && err != elf.ErrNoSymbols {
+		return nil, errors.WithStack(err)
+	}
+	for _, sym := range syms {
+		if elf.ST_TYPE(sym.Info) != elf.STT_FUNC || sym.Section == elf.SHN_UNDEF {
+			continue
+		}
+		exe.dynsyms[sym.Name] = sym.Value
+	}
+	return exe, nil
+}
+
+// elfRelocs returns the virtual addresses relocated by the entries of the given
+// loadable relocation section.
+func elfRelocs(file *elf.File, s *elf.Section) ([]uint64, error) {
+	data, err := s.Data()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read contents of section %q", s.Name)
+	}
+	// Size of relocation entries; the offset is stored in the first field.
+	entSize := s.Entsize
+	if entSize == 0 {
+		switch {
+		case file.Class == elf.ELFCLASS64 && s.Type == elf.SHT_RELA:
+			entSize = 24
+		case file.Class == elf.ELFCLASS64:
+			entSize = 16
+		case s.Type == elf.SHT_RELA:
+			entSize = 12
+		default:
+			entSize = 8
+		}
+	}
+	var relocs []uint64
+	for off := uint64(0); off+entSize <= uint64(len(data)); off += entSize {
+		if file.Class == elf.ELFCLASS64 {
+			relocs = append(relocs, file.ByteOrder.Uint64(data[off:]))
+		} else {
+			relocs = append(relocs, uint64(file.ByteOrder.Uint32(data[off:])))
+		}
+	}
+	return relocs, nil
+}
+
+// Machine returns the machine architecture of the executable.
+func (exe *elfExecutable) Machine() machine {
+	return exe.machine
+}
+
+// ImageBase returns the preferred base address of the executable image.
+func (exe *elfExecutable) ImageBase() uint64 {
+	return exe.imageBase
+}
+
+// Sections returns the sections of the executable, as loaded into memory.
+func (exe *elfExecutable) Sections() []*section {
+	return exe.sects
+}
+
+// ReadAt returns the n bytes at the given virtual address.
+func (exe *elfExecutable) ReadAt(addr uint64, n int64) ([]byte, error) {
+	return readSections(exe.sects, addr, n)
+}
+
+// Relocs returns the virtual addresses of absolute addresses relocated by the
+// loader when the image is loaded at a base address other than its preferred
+// one.
+func (exe *elfExecutable) Relocs() []uint64 {
+	return exe.relocs
+}
+
+// Lookup returns the virtual address of the function defined in the dynamic
+// symbol table of the executable with the given symbol name.
+func (exe *elfExecutable) Lookup(sym string) (uint64, error) {
+	if _, ok := parseOrdinal(sym); ok {
+		return 0, errors.Errorf("invalid symbol %q; ordinals not supported by ELF", sym)
+	}
+	addr, ok := exe.dynsyms[sym]
+	if !ok {
+		return 0, errors.Errorf("unable to locate dynamic symbol %q", sym)
+	}
+	return addr, nil
+}
+
+// ImportSlot returns the virtual address of the import address table slot of
+// the given imported function.
+func (exe *elfExecutable) ImportSlot(imp *importRef) (uint64, error) {
+	return 0, errors.Errorf("unable to locate import %q; import address table hooking not supported by ELF", imp)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mewmew/pe"
+)
+
+func TestPEImportSlot(t *testing.T) {
+	// Two import descriptors of KERNEL32.dll (e.g. as emitted when merging
+	// import libraries), the latter also importing by ordinal.
+	file := &pe.File{
+		OptHdr: &pe.OptHeader{ImageBase: 0x400000},
+		Imps: []pe.ImportEntry{
+			{
+				ImpDir: pe.ImportDirectory{Name: "KERNEL32.dll", IATRelAddr: 0x5000},
+				INTs: []pe.INTEntry{
+					{NameEntry: pe.NameEntry{Name: "GetTickCount"}},
+					{NameEntry: pe.NameEntry{Name: "Sleep"}},
+				},
+			},
+			{
+				ImpDir: pe.ImportDirectory{Name: "msvcrt.dll", IATRelAddr: 0x5100},
+				INTs: []pe.INTEntry{
+					{NameEntry: pe.NameEntry{Name: "puts"}},
+				},
+			},
+			{
+				ImpDir: pe.ImportDirectory{Name: "KERNEL32.dll", IATRelAddr: 0x5200},
+				// Import name table omitted.
+				IATs: []pe.INTEntry{
+					{NameEntry: pe.NameEntry{Name: "ExitProcess"}},
+					{IsOrdinal: true, Ordinal: 42},
+				},
+			},
+		},
+	}
+	exe := &peExecutable{file: file, machine: machine386}
+	golden := []struct {
+		imp  string
+		want uint64
+		err  bool
+	}{
+		{imp: "KERNEL32.dll!GetTickCount", want: 0x405000},
+		{imp: "KERNEL32.dll!Sleep", want: 0x405004},
+		{imp: "kernel32.dll!ExitProcess", want: 0x405200},
+		{imp: "KERNEL32.dll!#42", want: 0x405204},
+		{imp: "msvcrt.dll!puts", want: 0x405100},
+		{imp: "KERNEL32.dll!puts", err: true},
+		{imp: "user32.dll!MessageBoxA", err: true},
+	}
+	for _, g := range golden {
+		imp, err := parseImportRef(g.imp)
+		if err != nil {
+			t.Errorf("%q: unable to parse import; %v", g.imp, err)
+			continue
+		}
+		got, err := exe.ImportSlot(imp)
+		if g.err {
+			if err == nil {
+				t.Errorf("%q: expected error, got 0x%X", g.imp, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unable to locate import slot; %v", g.imp, err)
+			continue
+		}
+		if got != g.want {
+			t.Errorf("%q: import slot mismatch; expected 0x%X, got 0x%X", g.imp, g.want, got)
+		}
+	}
+}
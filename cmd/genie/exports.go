@@ -0,0 +1,159 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// peImage is the memory image of a PE file, accessed by relative address.
+type peImage interface {
+	// uint32At returns the 32-bit value at the given relative address.
+	uint32At(addr uint32) (uint32, bool)
+	// uint16At returns the 16-bit value at the given relative address.
+	uint16At(addr uint32) (uint16, bool)
+	// cString returns the NULL-terminated string at the given relative address.
+	cString(addr uint32) string
+}
+
+// peExports is the export table of a PE file.
+type peExports struct {
+	// Relative addresses of exported functions, by export name.
+	names map[string]uint32
+	// Relative addresses of exported functions, by ordinal.
+	ordinals map[uint32]uint32
+	// Relative address and size of export directory; forwarded exports refer
+	// to forwarder strings within the export directory.
+	dirAddr, dirSize uint32
+}
+
+// parsePEExports parses the export directory at the given relative address of
+// the PE image.
+func parsePEExports(img peImage, dirAddr, dirSize uint32) (*peExports, error) {
+	exports := &peExports{
+		names:    make(map[string]uint32),
+		ordinals: make(map[uint32]uint32),
+		dirAddr:  dirAddr,
+		dirSize:  dirSize,
+	}
+	if dirSize < 40 {
+		return exports, nil
+	}
+	var fields [5]uint32
+	for i := range fields {
+		v, ok := img.uint32At(dirAddr + 16 + 4*uint32(i))
+		if !ok {
+			return nil, errors.Errorf("invalid export directory at relative address 0x%X", dirAddr)
+		}
+		fields[i] = v
+	}
+	base, nfuncs, nnames, funcs, names := fields[0], fields[1], fields[2], fields[3], fields[4]
+	ordinals, ok := img.uint32At(dirAddr + 36)
+	if !ok {
+		return nil, errors.Errorf("invalid export directory at relative address 0x%X", dirAddr)
+	}
+	for i := uint32(0); i < nfuncs; i++ {
+		funcAddr, ok := img.uint32At(funcs + 4*i)
+		if !ok {
+			return nil, errors.Errorf("invalid export address table entry %d", i)
+		}
+		// Unused entries of the export address table are zero.
+		if funcAddr != 0 {
+			exports.ordinals[base+i] = funcAddr
+		}
+	}
+	for i := uint32(0); i < nnames; i++ {
+		nameAddr, ok := img.uint32At(names + 4*i)
+		if !ok {
+			return nil, errors.Errorf("invalid export name table entry %d", i)
+		}
+		ordinal, ok := img.uint16At(ordinals + 2*i)
+		if !ok || uint32(ordinal) >= nfuncs {
+			return nil, errors.Errorf("invalid export ordinal table entry %d", i)
+		}
+		funcAddr, ok := img.uint32At(funcs + 4*uint32(ordinal))
+		if !ok {
+			return nil, errors.Errorf("invalid export address table entry %d", ordinal)
+		}
+		exports.names[img.cString(nameAddr)] = funcAddr
+	}
+	return exports, nil
+}
+
+// lookup returns the relative address of the exported function with the given
+// C name, and a boolean indicating if such an export was located.
+//
+// If not exported by its C name, decorated export names are matched: __stdcall
+// ("name@N" and "_name@N"), __fastcall ("@name@N"), __vectorcall ("name@@N")
+// and C++ functions at global scope ("?name@@..."). An error is reported if the
+// C name matches several decorated export names.
+func (exports *peExports) lookup(name string) (uint32, bool, error) {
+	if addr, ok := exports.names[name]; ok {
+		return addr, true, nil
+	}
+	var matches []string
+	for export := range exports.names {
+		if isDecoratedName(export, name) {
+			matches = append(matches, export)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return 0, false, nil
+	case 1:
+		return exports.names[matches[0]], true, nil
+	}
+	sort.Strings(matches)
+	return 0, false, errors.Errorf("ambiguous export %q; matches decorated export names %q", name, matches)
+}
+
+// isDecoratedName reports whether the given export name is a decorated name of
+// the function with the specified C name (see lookup).
+func isDecoratedName(export, name string) bool {
+	switch {
+	case strings.HasPrefix(export, "?"):
+		return strings.HasPrefix(export, "?"+name+"@@")
+	case strings.HasPrefix(export, name+"@"):
+		return true
+	case strings.HasPrefix(export, "@"), strings.HasPrefix(export, "_"):
+		return strings.HasPrefix(export[1:], name+"@")
+	}
+	return false
+}
+
+// lookupSym returns the relative address of the exported function identified
+// by the given symbol; either an export name (see lookup) or, as a fallback
+// for functions exported by ordinal only, an ordinal of the form "#N".
+func (exports *peExports) lookupSym(sym string) (uint32, error) {
+	addr, ok, err := exports.lookup(sym)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	if !ok {
+		ordinal, isOrdinal := parseOrdinal(sym)
+		if !isOrdinal {
+			return 0, errors.Errorf("unable to locate export %q", sym)
+		}
+		if addr, ok = exports.ordinals[ordinal]; !ok {
+			return 0, errors.Errorf("unable to locate export with ordinal %d", ordinal)
+		}
+	}
+	if exports.dirAddr <= addr && addr < exports.dirAddr+exports.dirSize {
+		return 0, errors.Errorf("export %q forwarded to another DLL", sym)
+	}
+	return addr, nil
+}
+
+// parseOrdinal parses the given ordinal symbol of the form "#N".
+func parseOrdinal(sym string) (uint32, bool) {
+	if !strings.HasPrefix(sym, "#") {
+		return 0, false
+	}
+	ordinal, err := strconv.ParseUint(sym[1:], 0, 16)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(ordinal), true
+}
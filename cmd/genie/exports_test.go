@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestPEExportsLookup(t *testing.T) {
+	exports := &peExports{
+		names: map[string]uint32{
+			"plain":           0x1000,
+			"std@8":           0x1010,
+			"_ustd@4":         0x1020,
+			"@fast@8":         0x1030,
+			"vec@@16":         0x1040,
+			"?cpp@@YAHH@Z":    0x1050,
+			"dup@4":           0x1060,
+			"_dup@4":          0x1070,
+			"?over@@YAHH@Z":   0x1080,
+			"?over@@YAHN@Z":   0x1090,
+			"_under":          0x10A0,
+			"_under2@4":       0x10B0,
+			"?cpp2@ns@@YAXXZ": 0x10C0,
+		},
+	}
+	golden := []struct {
+		name      string
+		want      uint32
+		ok        bool
+		ambiguous bool
+	}{
+		{name: "plain", want: 0x1000, ok: true},
+		{name: "std", want: 0x1010, ok: true},
+		{name: "ustd", want: 0x1020, ok: true},
+		{name: "fast", want: 0x1030, ok: true},
+		{name: "vec", want: 0x1040, ok: true},
+		{name: "cpp", want: 0x1050, ok: true},
+		{name: "_under", want: 0x10A0, ok: true},
+		{name: "_under2", want: 0x10B0, ok: true},
+		{name: "under2", want: 0x10B0, ok: true},
+		{name: "dup", ambiguous: true},
+		{name: "over", ambiguous: true},
+		// Member functions are not matched by their C name.
+		{name: "cpp2", ok: false},
+		{name: "missing", ok: false},
+		{name: "st", ok: false},
+	}
+	for _, g := range golden {
+		// Repeat lookups to detect dependence on map iteration order.
+		for i := 0; i < 10; i++ {
+			got, ok, err := exports.lookup(g.name)
+			if g.ambiguous {
+				if err == nil {
+					t.Errorf("%q: expected ambiguity error, got 0x%X", g.name, got)
+				}
+				break
+			}
+			if err != nil {
+				t.Errorf("%q: unexpected error; %v", g.name, err)
+				break
+			}
+			if got != g.want || ok != g.ok {
+				t.Errorf("%q: lookup mismatch; expected (0x%X, %v), got (0x%X, %v)", g.name, g.want, g.ok, got, ok)
+				break
+			}
+		}
+	}
+}
+
+// testImage is a PE image of which the relative addresses are offsets into the
+// byte slice.
+type testImage []byte
+
+func (img testImage) uint32At(addr uint32) (uint32, bool) {
+	if int(addr)+4 > len(img) {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(img[addr:]), true
+}
+
+func (img testImage) uint16At(addr uint32) (uint16, bool) {
+	if int(addr)+2 > len(img) {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint16(img[addr:]), true
+}
+
+func (img testImage) cString(addr uint32) string {
+	buf := img[addr:]
+	if pos := bytes.IndexByte(buf, 0); pos != -1 {
+		buf = buf[:pos]
+	}
+	return string(buf)
+}
+
+func TestParsePEExports(t *testing.T) {
+	const dirAddr, dirSize = 0x40, 0x60
+	img := make(testImage, 0x200)
+	put32 := func(addr, v uint32) { binary.LittleEndian.PutUint32(img[addr:], v) }
+	// Ordinal base, number of functions and names, and addresses of the export
+	// address, name and ordinal tables.
+	put32(dirAddr+16, 1)
+	put32(dirAddr+20, 3)
+	put32(dirAddr+24, 2)
+	put32(dirAddr+28, 0x100)
+	put32(dirAddr+32, 0x120)
+	put32(dirAddr+36, 0x130)
+	// The second entry is unused, and the third is forwarded (located within
+	// the export directory).
+	put32(0x100, 0x1000)
+	put32(0x108, 0x50)
+	put32(0x120, 0x140)
+	put32(0x124, 0x150)
+	binary.LittleEndian.PutUint16(img[0x130:], 0)
+	binary.LittleEndian.PutUint16(img[0x132:], 2)
+	copy(img[0x140:], "foo\x00")
+	copy(img[0x150:], "fwd\x00")
+	exports, err := parsePEExports(img, dirAddr, dirSize)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	golden := []struct {
+		sym string
+		// Expected relative address; or zero if an error is expected.
+		want uint32
+	}{
+		{sym: "foo", want: 0x1000},
+		{sym: "#1", want: 0x1000},
+		{sym: "#0x1", want: 0x1000},
+		// Unused entry.
+		{sym: "#2"},
+		// Forwarded export.
+		{sym: "fwd"},
+		{sym: "#3"},
+		{sym: "bar"},
+		{sym: "#bar"},
+	}
+	for _, g := range golden {
+		got, err := exports.lookupSym(g.sym)
+		if g.want == 0 {
+			if err == nil {
+				t.Errorf("%q: expected error, got 0x%X", g.sym, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %+v", g.sym, err)
+			continue
+		}
+		if got != g.want {
+			t.Errorf("%q: address mismatch; expected 0x%X, got 0x%X", g.sym, g.want, got)
+		}
+	}
+	// Truncated export directories are reported.
+	if _, err := parsePEExports(img[:0x60], dirAddr, dirSize); err == nil {
+		t.Errorf("truncated export directory: expected error, got nil")
+	}
+}
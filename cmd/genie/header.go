@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/mewmew/genie/ctype"
+	"github.com/pkg/errors"
+)
+
+// headerGen collects the type definitions and function prototypes of the C
+// header (export.h) included by the generated C source code.
+type headerGen struct {
+	// Structure and union types to forward declare (e.g. "struct foo").
+	fwdDecls map[string]bool
+	// Type definitions, in dependency order.
+	defs []string
+	// Function prototypes.
+	protos []string
+	// Tracks type definitions output, by tag or type name.
+	defined map[string]bool
+	// Tracks type definitions in progress, by tag; used to break cycles of
+	// recursive types.
+	inProgress map[string]bool
+	// System headers required by basic types.
+	includes map[string]bool
+}
+
+// newHeaderGen returns a new C header generator.
+func newHeaderGen() *headerGen {
+	return &headerGen{
+		fwdDecls:   make(map[string]bool),
+		defined:    make(map[string]bool),
+		inProgress: make(map[string]bool),
+		includes:   map[string]bool{"<stdio.h>": true},
+	}
+}
+
+// addFunc adds the prototype of the given function, and the definitions of the
+// types used by the function.
+func (h *headerGen) addFunc(funcType *ctype.FuncType, funcName string) {
+	h.addType(funcType.RetType, true)
+	for _, param := range funcType.ParamTypes {
+		h.addType(param, true)
+	}
+	h.protos = append(h.protos, ctype.Decl(funcType, funcName)+";")
+}
+
+// addType adds the definitions of the given type and of the types it depends
+// on, in dependency order. If complete is set, the type must be complete (e.g.
+// structure types of fields and array elements); otherwise an incomplete type
+// is sufficient (e.g. the element type of pointers).
+func (h *headerGen) addType(t ctype.Type, complete bool) {
+	switch t := t.(type) {
+	case ctype.BasicType:
+		switch t {
+		case ctype.BasicTypeWChar:
+			h.includes["<stddef.h>"] = true
+		case ctype.BasicTypeChar16, ctype.BasicTypeChar32:
+			h.includes["<uchar.h>"] = true
+		}
+	case *ctype.ConstType:
+		h.addType(t.Typ, complete)
+	case *ctype.PointerType:
+		h.addType(t.Elem, false)
+	case *ctype.ArrayType:
+		h.addType(t.Elem, true)
+	case *ctype.FuncType:
+		h.addType(t.RetType, false)
+		for _, param := range t.ParamTypes {
+			h.addType(param, false)
+		}
+	case *ctype.EnumType:
+		if len(t.Name) == 0 || h.defined[t.String()] {
+			return
+		}
+		// Enum types with enumerators cannot be forward declared. Enum types
+		// without enumerators (e.g. declared but not defined in the debug
+		// information) are forward declared, as supported by GCC and Clang.
+		if len(t.Enumerators) == 0 {
+			h.fwdDecls[t.String()] = true
+			return
+		}
+		h.defined[t.String()] = true
+		h.defs = append(h.defs, t.CString())
+	case *ctype.StructType:
+		h.addComposite(t, t.Name, t.Fields, complete)
+	case *ctype.UnionType:
+		h.addComposite(t, t.Name, t.Fields, complete)
+	case *ctype.Typedef:
+		if !h.defined[t.Name] {
+			h.defined[t.Name] = true
+			// Anonymous underlying types are defined in place by the type
+			// definition, and the definitions of their fields are thus added
+			// before the type definition.
+			h.addType(t.Typ, false)
+			h.defs = append(h.defs, t.CString())
+		}
+		if complete {
+			h.addType(t.Typ, true)
+		}
+	}
+}
+
+// addComposite adds the definition of the given structure or union type with
+// the specified tag and fields. Named structure and union types are forward
+// declared, so that they may be referred to before they are defined (e.g. by
+// recursive types).
+func (h *headerGen) addComposite(t ctype.Type, tag string, fields []*ctype.Field, complete bool) {
+	if len(tag) == 0 {
+		// Anonymous types are defined in place; thus their fields must be
+		// complete.
+		for _, field := range fields {
+			h.addType(field.Typ, true)
+		}
+		return
+	}
+	spec := t.String()
+	h.fwdDecls[spec] = true
+	if !complete || h.defined[spec] || h.inProgress[spec] || len(fields) == 0 {
+		return
+	}
+	h.inProgress[spec] = true
+	for _, field := range fields {
+		h.addType(field.Typ, true)
+	}
+	delete(h.inProgress, spec)
+	h.defined[spec] = true
+	h.defs = append(h.defs, t.CString())
+}
+
+// writeFile writes the C header to the given path.
+func (h *headerGen) writeFile(headerPath string) error {
+	buf := &bytes.Buffer{}
+	buf.WriteString("// Code generated by genie; DO NOT EDIT.\n\n")
+	buf.WriteString("#ifndef GENIE_EXPORT_H\n#define GENIE_EXPORT_H\n\n")
+	for _, include := range sortedKeys(h.includes) {
+		fmt.Fprintf(buf, "#include %s\n", include)
+	}
+	if len(h.fwdDecls) > 0 {
+		buf.WriteString("\n")
+		for _, spec := range sortedKeys(h.fwdDecls) {
+			fmt.Fprintf(buf, "%s;\n", spec)
+		}
+	}
+	for _, def := range h.defs {
+		fmt.Fprintf(buf, "\n%s\n", def)
+	}
+	if len(h.protos) > 0 {
+		buf.WriteString("\n")
+		for _, proto := range h.protos {
+			fmt.Fprintln(buf, proto)
+		}
+	}
+	buf.WriteString("\n#endif // GENIE_EXPORT_H\n")
+	if err := ioutil.WriteFile(headerPath, buf.Bytes(), 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of the given map in sorted order.
+func sortedKeys(m map[string]bool) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mewmew/genie/ctype"
+)
+
+func TestHeaderForwardDeclaredEnum(t *testing.T) {
+	// void f(enum opaque *p, enum color c);
+	opaque := &ctype.EnumType{Name: "opaque"}
+	color := &ctype.EnumType{
+		Name: "color",
+		Enumerators: []*ctype.Enumerator{
+			{Name: "RED", Value: 0},
+		},
+	}
+	funcType := &ctype.FuncType{
+		RetType:    ctype.BasicTypeVoid,
+		ParamTypes: []ctype.Type{&ctype.PointerType{Elem: opaque}, color},
+		ParamNames: []string{"p", "c"},
+	}
+	h := newHeaderGen()
+	h.addFunc(funcType, "f")
+	headerPath := filepath.Join(t.TempDir(), "export.h")
+	if err := h.writeFile(headerPath); err != nil {
+		t.Fatalf("unable to write header; %v", err)
+	}
+	buf, err := ioutil.ReadFile(headerPath)
+	if err != nil {
+		t.Fatalf("unable to read header; %v", err)
+	}
+	header := string(buf)
+	for _, want := range []string{"\nenum opaque;\n", "\nenum color {\n\tRED = 0,\n};\n"} {
+		if !strings.Contains(header, want) {
+			t.Errorf("header missing %q:\n%s", want, header)
+		}
+	}
+	if strings.Contains(header, "enum opaque {") {
+		t.Errorf("header defines enum without enumerators:\n%s", header)
+	}
+}
+
+func TestHeaderTypes(t *testing.T) {
+	// struct node { struct node *next; };
+	// typedef struct node node_t;
+	// typedef struct { int x; int y; wchar_t c; } point;
+	// void f(node_t *n, point p);
+	node := &ctype.StructType{Name: "node"}
+	node.Fields = []*ctype.Field{
+		{Name: "next", Typ: &ctype.PointerType{Elem: node}},
+	}
+	nodeT := &ctype.Typedef{Name: "node_t", Typ: node}
+	point := &ctype.Typedef{
+		Name: "point",
+		Typ: &ctype.StructType{
+			Fields: []*ctype.Field{
+				{Name: "x", Typ: ctype.BasicTypeInt},
+				{Name: "y", Typ: ctype.BasicTypeInt},
+				{Name: "c", Typ: ctype.BasicTypeWChar},
+			},
+		},
+	}
+	funcType := &ctype.FuncType{
+		RetType:    ctype.BasicTypeVoid,
+		ParamTypes: []ctype.Type{&ctype.PointerType{Elem: nodeT}, point},
+		ParamNames: []string{"n", "p"},
+	}
+	h := newHeaderGen()
+	h.addFunc(funcType, "f")
+	h.addFunc(funcType, "g")
+	headerPath := filepath.Join(t.TempDir(), "export.h")
+	if err := h.writeFile(headerPath); err != nil {
+		t.Fatalf("unable to write header; %v", err)
+	}
+	buf, err := ioutil.ReadFile(headerPath)
+	if err != nil {
+		t.Fatalf("unable to read header; %v", err)
+	}
+	header := string(buf)
+	// Expected contents, in order.
+	wants := []string{
+		"#include <stddef.h>\n",
+		"#include <stdio.h>\n",
+		"\nstruct node;\n",
+		"\ntypedef struct node node_t;\n",
+		"\ntypedef struct {\n\tint x;\n\tint y;\n\twchar_t c;\n} point;\n",
+		"\nvoid f(node_t *n, point p);\nvoid g(node_t *n, point p);\n",
+	}
+	pos := 0
+	for _, want := range wants {
+		i := strings.Index(header[pos:], want)
+		if i == -1 {
+			t.Errorf("header missing %q after offset %d:\n%s", want, pos, header)
+			continue
+		}
+		pos += i + len(want)
+	}
+	// The structure type is only needed as an incomplete type.
+	if strings.Contains(header, "struct node {") {
+		t.Errorf("header defines structure type only used through pointers:\n%s", header)
+	}
+	// Type definitions are output once.
+	if n := strings.Count(header, "typedef struct node node_t;"); n != 1 {
+		t.Errorf("type definition count mismatch; expected 1, got %d", n)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	stdpe "debug/pe"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// Data directory indices.
+const (
+	dataDirExport    = 0
+	dataDirImport    = 1
+	dataDirCert      = 4
+	dataDirBaseReloc = 5
+)
+
+// Base relocation types.
+const (
+	relocAbsolute = 0
+	relocHighLow  = 3
+	relocDir64    = 10
+)
+
+// hookDLL is a compiled hook DLL, mapped into memory as by the Windows loader.
+//
+// The DLL is parsed using debug/pe, as DLLs contain export tables (and x86-64
+// DLLs exception tables) not yet supported by github.com/mewmew/pe.
+type hookDLL struct {
+	// Memory image of DLL; sections mapped at their relative addresses.
+	image []byte
+	// Preferred base address of image.
+	imageBase uint64
+	// Machine type of DLL.
+	machine uint16
+	// PE32+ (64-bit) DLL.
+	pe32Plus bool
+	// Data directories.
+	dataDirs [16]stdpe.DataDirectory
+	// Export table.
+	exports *peExports
+}
+
+// loadHookDLL loads and maps the given hook DLL into memory.
+func loadHookDLL(dllPath string) (*hookDLL, error) {
+	f, err := stdpe.Open(dllPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+	dll := &hookDLL{
+		machine: f.Machine,
+	}
+	var imageSize uint32
+	switch opt := f.OptionalHeader.(type) {
+	case *stdpe.OptionalHeader32:
+		dll.imageBase = uint64(opt.ImageBase)
+		imageSize = opt.SizeOfImage
+		copy(dll.dataDirs[:], opt.DataDirectory[:opt.NumberOfRvaAndSizes])
+	case *stdpe.OptionalHeader64:
+		dll.imageBase = opt.ImageBase
+		imageSize = opt.SizeOfImage
+		dll.pe32Plus = true
+		copy(dll.dataDirs[:], opt.DataDirectory[:opt.NumberOfRvaAndSizes])
+	default:
+		return nil, errors.Errorf("missing optional header of %q", dllPath)
+	}
+	if f.Characteristics&stdpe.IMAGE_FILE_DLL == 0 {
+		return nil, errors.Errorf("%q is not a DLL", dllPath)
+	}
+	dll.image = make([]byte, imageSize)
+	for _, sect := range f.Sections {
+		data, err := sect.Data()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if len(data) > int(sect.VirtualSize) {
+			data = data[:sect.VirtualSize]
+		}
+		if int(sect.VirtualAddress)+len(data) > len(dll.image) {
+			return nil, errors.Errorf("section %q of %q outside of image", sect.Name, dllPath)
+		}
+		copy(dll.image[sect.VirtualAddress:], data)
+	}
+	exportDir := dll.dataDirs[dataDirExport]
+	dll.exports, err = parsePEExports(dll, exportDir.VirtualAddress, exportDir.Size)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return dll, nil
+}
+
+// dataDir returns the contents of the given data directory; or nil if not
+// present.
+func (dll *hookDLL) dataDir(idx int) ([]byte, error) {
+	dataDir := dll.dataDirs[idx]
+	if dataDir.Size == 0 {
+		return nil, nil
+	}
+	start, end := uint64(dataDir.VirtualAddress), uint64(dataDir.VirtualAddress)+uint64(dataDir.Size)
+	if end > uint64(len(dll.image)) {
+		return nil, errors.Errorf("data directory %d outside of image", idx)
+	}
+	return dll.image[start:end], nil
+}
+
+// lookup returns the relative address of the exported function with the given
+// C name, taking into account name decoration (see peExports.lookup), and a
+// boolean indicating if such an export was located.
+func (dll *hookDLL) lookup(name string) (uint32, bool, error) {
+	return dll.exports.lookup(name)
+}
+
+// relocate applies the base relocations of the DLL, as loaded at the given base
+// address.
+func (dll *hookDLL) relocate(base uint64) error {
+	buf, err := dll.dataDir(dataDirBaseReloc)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	delta := base - dll.imageBase
+	for len(buf) >= 8 {
+		pageAddr := binary.LittleEndian.Uint32(buf)
+		blockSize := binary.LittleEndian.Uint32(buf[4:])
+		if blockSize < 8 || int(blockSize) > len(buf) {
+			return errors.Errorf("invalid base relocation block size %d", blockSize)
+		}
+		for entries := buf[8:blockSize]; len(entries) >= 2; entries = entries[2:] {
+			entry := binary.LittleEndian.Uint16(entries)
+			typ, addr := entry>>12, pageAddr+uint32(entry&0xFFF)
+			switch typ {
+			case relocAbsolute:
+				// Padding.
+			case relocHighLow:
+				v, ok := dll.uint32At(addr)
+				if !ok {
+					return errors.Errorf("invalid base relocation at relative address 0x%X", addr)
+				}
+				binary.LittleEndian.PutUint32(dll.image[addr:], v+uint32(delta))
+			case relocDir64:
+				if uint64(addr)+8 > uint64(len(dll.image)) {
+					return errors.Errorf("invalid base relocation at relative address 0x%X", addr)
+				}
+				v := binary.LittleEndian.Uint64(dll.image[addr:])
+				binary.LittleEndian.PutUint64(dll.image[addr:], v+delta)
+			default:
+				return errors.Errorf("support for base relocation type %d not yet implemented", typ)
+			}
+		}
+		buf = buf[blockSize:]
+	}
+	dll.imageBase = base
+	return nil
+}
+
+// importDescs returns the import descriptors of the DLL (without terminating
+// null descriptor), with relative addresses adjusted for the DLL image located
+// at the given relative address of another image. The import lookup and import
+// address tables of the DLL are adjusted in place.
+func (dll *hookDLL) importDescs(imageAddr uint32) ([]byte, error) {
+	buf, err := dll.dataDir(dataDirImport)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var descs []byte
+	for ; len(buf) >= 20; buf = buf[20:] {
+		desc := make([]byte, 20)
+		copy(desc, buf)
+		lookupAddr := binary.LittleEndian.Uint32(desc[0:])
+		nameAddr := binary.LittleEndian.Uint32(desc[12:])
+		iatAddr := binary.LittleEndian.Uint32(desc[16:])
+		if lookupAddr == 0 && nameAddr == 0 && iatAddr == 0 {
+			break
+		}
+		for i, tableAddr := range []uint32{lookupAddr, iatAddr} {
+			// The import lookup table may be omitted or coincide with the
+			// import address table.
+			if tableAddr == 0 || (i == 1 && tableAddr == lookupAddr) {
+				continue
+			}
+			if err := dll.adjustThunks(tableAddr, imageAddr); err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+		// Clear time stamp of bound imports, as the import address table is
+		// not bound for the new image.
+		binary.LittleEndian.PutUint32(desc[4:], 0)
+		for _, offset := range []int{0, 12, 16} {
+			if addr := binary.LittleEndian.Uint32(desc[offset:]); addr != 0 {
+				binary.LittleEndian.PutUint32(desc[offset:], addr+imageAddr)
+			}
+		}
+		descs = append(descs, desc...)
+	}
+	return descs, nil
+}
+
+// adjustThunks adjusts the relative addresses of hint/name entries in the
+// import lookup or import address table at the given relative address.
+func (dll *hookDLL) adjustThunks(tableAddr, imageAddr uint32) error {
+	size := uint32(4)
+	if dll.pe32Plus {
+		size = 8
+	}
+	for addr := tableAddr; ; addr += size {
+		if uint64(addr)+uint64(size) > uint64(len(dll.image)) {
+			return errors.Errorf("invalid import table at relative address 0x%X", tableAddr)
+		}
+		if dll.pe32Plus {
+			v := binary.LittleEndian.Uint64(dll.image[addr:])
+			if v == 0 {
+				return nil
+			}
+			if v&(1<<63) == 0 {
+				binary.LittleEndian.PutUint64(dll.image[addr:], v+uint64(imageAddr))
+			}
+			continue
+		}
+		v := binary.LittleEndian.Uint32(dll.image[addr:])
+		if v == 0 {
+			return nil
+		}
+		if v&(1<<31) == 0 {
+			binary.LittleEndian.PutUint32(dll.image[addr:], v+imageAddr)
+		}
+	}
+}
+
+// uint32At returns the 32-bit value at the given relative address of the DLL.
+func (dll *hookDLL) uint32At(addr uint32) (uint32, bool) {
+	if uint64(addr)+4 > uint64(len(dll.image)) {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(dll.image[addr:]), true
+}
+
+// uint16At returns the 16-bit value at the given relative address of the DLL.
+func (dll *hookDLL) uint16At(addr uint32) (uint16, bool) {
+	if uint64(addr)+2 > uint64(len(dll.image)) {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint16(dll.image[addr:]), true
+}
+
+// cString returns the NULL-terminated string at the given relative address of
+// the DLL.
+func (dll *hookDLL) cString(addr uint32) string {
+	if uint64(addr) >= uint64(len(dll.image)) {
+		return ""
+	}
+	buf := dll.image[addr:]
+	if pos := bytes.IndexByte(buf, 0); pos != -1 {
+		buf = buf[:pos]
+	}
+	return string(buf)
+}
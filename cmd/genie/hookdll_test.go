@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestHookDLLRelocate(t *testing.T) {
+	golden := []struct {
+		name string
+		// Relative address of page and relocation entries of the page.
+		page    uint32
+		entries []uint16
+		// Relative address and preferred and expected relocated value.
+		addr      uint32
+		v, want   uint64
+		size      int
+		imageBase uint64
+		base      uint64
+		err       bool
+	}{
+		{
+			name:    "HIGHLOW",
+			entries: []uint16{relocHighLow<<12 | 0x10, relocAbsolute << 12},
+			addr:    0x10, v: 0x10001234, want: 0x20001234, size: 4,
+			imageBase: 0x10000000, base: 0x20000000,
+		},
+		{
+			name:    "DIR64",
+			entries: []uint16{relocDir64<<12 | 0x20},
+			addr:    0x20, v: 0x180001000, want: 0x7FF000001000, size: 8,
+			imageBase: 0x180000000, base: 0x7FF000000000,
+		},
+		{
+			name:    "unsupported",
+			entries: []uint16{5<<12 | 0x10},
+			err:     true,
+		},
+		{
+			name:    "outside image",
+			page:    0x1000,
+			entries: []uint16{relocHighLow<<12 | 0xFFE},
+			err:     true,
+		},
+	}
+	for _, g := range golden {
+		dll := &hookDLL{image: make([]byte, 0x2000), imageBase: g.imageBase}
+		block := dll.image[0x1000:]
+		binary.LittleEndian.PutUint32(block[0:], g.page)
+		blockSize := 8 + 2*len(g.entries)
+		binary.LittleEndian.PutUint32(block[4:], uint32(blockSize))
+		for i, entry := range g.entries {
+			binary.LittleEndian.PutUint16(block[8+2*i:], entry)
+		}
+		dll.dataDirs[dataDirBaseReloc].VirtualAddress = 0x1000
+		dll.dataDirs[dataDirBaseReloc].Size = uint32(blockSize)
+		switch g.size {
+		case 4:
+			binary.LittleEndian.PutUint32(dll.image[g.addr:], uint32(g.v))
+		case 8:
+			binary.LittleEndian.PutUint64(dll.image[g.addr:], g.v)
+		}
+		err := dll.relocate(g.base)
+		if g.err {
+			if err == nil {
+				t.Errorf("%s: expected error, got nil", g.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %+v", g.name, err)
+			continue
+		}
+		var got uint64
+		switch g.size {
+		case 4:
+			got = uint64(binary.LittleEndian.Uint32(dll.image[g.addr:]))
+		case 8:
+			got = binary.LittleEndian.Uint64(dll.image[g.addr:])
+		}
+		if got != g.want {
+			t.Errorf("%s: relocated value mismatch; expected 0x%X, got 0x%X", g.name, g.want, got)
+		}
+		if dll.imageBase != g.base {
+			t.Errorf("%s: image base mismatch; expected 0x%X, got 0x%X", g.name, g.base, dll.imageBase)
+		}
+	}
+}
+
+func TestHookDLLImportDescs(t *testing.T) {
+	const imageAddr = 0x5000
+	golden := []struct {
+		pe32Plus bool
+		// Size of import lookup and import address table entries.
+		size int
+		// Ordinal import flag.
+		ordinal uint64
+	}{
+		{pe32Plus: false, size: 4, ordinal: 1 << 31},
+		{pe32Plus: true, size: 8, ordinal: 1 << 63},
+	}
+	for _, g := range golden {
+		dll := &hookDLL{image: make([]byte, 0x1000), pe32Plus: g.pe32Plus}
+		put := func(addr uint32, v uint64) {
+			if g.size == 4 {
+				binary.LittleEndian.PutUint32(dll.image[addr:], uint32(v))
+			} else {
+				binary.LittleEndian.PutUint64(dll.image[addr:], v)
+			}
+		}
+		get := func(addr uint32) uint64 {
+			if g.size == 4 {
+				return uint64(binary.LittleEndian.Uint32(dll.image[addr:]))
+			}
+			return binary.LittleEndian.Uint64(dll.image[addr:])
+		}
+		// Import descriptor followed by null descriptor.
+		desc := dll.image[0x100:]
+		binary.LittleEndian.PutUint32(desc[0:], 0x200)
+		binary.LittleEndian.PutUint32(desc[4:], 0xFFFFFFFF)
+		binary.LittleEndian.PutUint32(desc[12:], 0x300)
+		binary.LittleEndian.PutUint32(desc[16:], 0x280)
+		dll.dataDirs[dataDirImport].VirtualAddress = 0x100
+		dll.dataDirs[dataDirImport].Size = 40
+		// Import lookup and import address tables; hint/name entry and ordinal.
+		for _, tableAddr := range []uint32{0x200, 0x280} {
+			put(tableAddr, 0x400)
+			put(tableAddr+uint32(g.size), g.ordinal|0x10)
+		}
+		descs, err := dll.importDescs(imageAddr)
+		if err != nil {
+			t.Errorf("%+v", err)
+			continue
+		}
+		if len(descs) != 20 {
+			t.Errorf("import descriptors size mismatch; expected 20, got %d", len(descs))
+			continue
+		}
+		for offset, want := range map[int]uint32{0: 0x5200, 4: 0, 8: 0, 12: 0x5300, 16: 0x5280} {
+			if got := binary.LittleEndian.Uint32(descs[offset:]); got != want {
+				t.Errorf("import descriptor field at offset %d mismatch; expected 0x%X, got 0x%X", offset, want, got)
+			}
+		}
+		for _, tableAddr := range []uint32{0x200, 0x280} {
+			if got := get(tableAddr); got != 0x5400 {
+				t.Errorf("hint/name entry mismatch; expected 0x5400, got 0x%X", got)
+			}
+			if got, want := get(tableAddr+uint32(g.size)), g.ordinal|0x10; got != want {
+				t.Errorf("ordinal entry mismatch; expected 0x%X, got 0x%X", want, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// importRef identifies a function imported by the original executable, which
+// is hooked by swapping the pointer stored in its import address table (IAT)
+// slot, instead of patching the function itself.
+//
+// The imported function is annotated by storing a string of the form
+// "DLL!NAME" to a local variable named `iat`, or with `@ iat "DLL!NAME"` in C
+// headers, where NAME is either the import name or an ordinal of the form
+// "#N".
+//
+// Example:
+//
+//	int my_puts(const char *s) {
+//	   char *iat = "msvcrt.dll!puts";
+//	}
+//
+// IAT hooks are installed at runtime (see install_hooks_genie), as the import
+// address table is overwritten by the loader. The hook calls the original
+// function through the pointer stored in the IAT slot prior to installing the
+// hook.
+type importRef struct {
+	// DLL name (e.g. "KERNEL32.dll"); matched case-insensitively.
+	DLL string
+	// Import name or ordinal of the form "#N".
+	Sym string
+}
+
+// parseImportRef parses the given imported function reference of the form
+// "DLL!NAME".
+func parseImportRef(s string) (*importRef, error) {
+	pos := strings.LastIndex(s, "!")
+	if pos == -1 || pos == 0 || pos == len(s)-1 {
+		return nil, errors.Errorf("invalid imported function %q; expected \"DLL!NAME\"", s)
+	}
+	return &importRef{DLL: s[:pos], Sym: s[pos+1:]}, nil
+}
+
+// String returns the string representation of the imported function reference.
+func (imp *importRef) String() string {
+	return imp.DLL + "!" + imp.Sym
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestParseImportRef(t *testing.T) {
+	golden := []struct {
+		s string
+		// Expected import; or nil if an error is expected.
+		want *importRef
+	}{
+		{s: "msvcrt.dll!puts", want: &importRef{DLL: "msvcrt.dll", Sym: "puts"}},
+		{s: "KERNEL32.dll!#12", want: &importRef{DLL: "KERNEL32.dll", Sym: "#12"}},
+		// The last separator splits the DLL name from the import name.
+		{s: "a!b.dll!c", want: &importRef{DLL: "a!b.dll", Sym: "c"}},
+		{s: "puts"},
+		{s: "!puts"},
+		{s: "msvcrt.dll!"},
+	}
+	for _, g := range golden {
+		got, err := parseImportRef(g.s)
+		if g.want == nil {
+			if err == nil {
+				t.Errorf("%q: expected error, got %v", g.s, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %+v", g.s, err)
+			continue
+		}
+		if *got != *g.want {
+			t.Errorf("%q: import mismatch; expected %+v, got %+v", g.s, g.want, got)
+		}
+		if got.String() != g.s {
+			t.Errorf("%q: string mismatch; got %q", g.s, got.String())
+		}
+	}
+}
@@ -1,65 +1,164 @@
 package main
 
 import (
+	"bytes"
 	_ "embed"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"math/bits"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"text/tabwriter"
 	"text/template"
 
-	"github.com/llir/llvm/asm"
 	"github.com/llir/llvm/ir"
 	"github.com/llir/llvm/ir/constant"
 	"github.com/llir/llvm/ir/enum"
 	"github.com/llir/llvm/ir/metadata"
+	"github.com/llir/llvm/ir/types"
 	"github.com/llir/llvm/ir/value"
+	"github.com/mewmew/genie/cparse"
 	"github.com/mewmew/genie/ctype"
 	"github.com/mewmew/genie/mdutil"
-	"github.com/mewmew/pe"
 	"github.com/pkg/errors"
 )
 
 func usage() {
 	const use = `
-Usage: genie [OPTION]... FILE.ll...
+Usage: genie [OPTION]... FILE.{ll,h}...
+       genie patch [OPTION]... FILE.{ll,h}...
+
+Generate C hooks of the functions declared by stubs in LLVM IR (compiled from
+C with debug information, the address stored in a local variable named addr),
+or by C prototypes annotated with addresses in C headers (e.g.
+"int __stdcall foo(int x) @ 0x401230;").
+
+Exported functions may be located by symbol name instead; stored in a local
+variable named addr_sym, or annotated as a string (e.g. @ "foo"). Ordinals of
+PE exports are given as "#N".
+
+Functions imported by PE executables may be hooked through their import
+address table slot instead, swapped at runtime; stored in a local variable
+named iat, or annotated as @ iat "DLL!NAME" (e.g. @ iat "msvcrt.dll!puts").
+
+Hooks preserve all registers (no_caller_saved_registers attribute), which GCC
+only supports with -mgeneral-regs-only; as SSE is enabled by default on x86-64,
+compile hooks of x86-64 executables with -mgeneral-regs-only.
 `
 	fmt.Fprintln(os.Stderr, use[1:])
 	flag.PrintDefaults()
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "patch" {
+		patchMain(os.Args[2:])
+		return
+	}
 	var (
-		// Path to original PE binary executable.
+		// Path to original PE or ELF binary executable.
 		origPath string
 		// Output path of C source code.
 		output string
+		// Comma-separated list of enum types printed as sets of bit flags.
+		flagEnums string
+		// Skip functions using unsupported features.
+		skip bool
+		// Output path of C header.
+		headerPath string
+		// Resolve addresses relative to the runtime module base.
+		rva bool
+		// Name of module containing the functions to hook.
+		module string
 	)
-	flag.StringVar(&origPath, "orig", "orig.exe", "path to original PE binary executable")
+	flag.StringVar(&origPath, "orig", "orig.exe", "path to original PE or ELF binary executable")
 	flag.StringVar(&output, "o", "", "output path of C source code (default stdout)")
+	flag.StringVar(&flagEnums, "flagenums", "", "comma-separated list of enum types (tags or typedef names) to print as sets of bit flags")
+	flag.BoolVar(&skip, "skip", false, "skip and report functions using unsupported types or calling conventions")
+	flag.StringVar(&headerPath, "header", "export.h", "output path of C header with type definitions and prototypes (empty to disable)")
+	flag.BoolVar(&rva, "rva", false, "resolve addresses as RVAs relative to the runtime module base, and install hooks at runtime (ASLR-aware)")
+	flag.StringVar(&module, "module", "", "name of module (e.g. foo.dll) containing the functions to hook; implies -rva (default main executable)")
 	flag.Usage = usage
 	flag.Parse()
-	llPaths := flag.Args()
-	for _, llPath := range llPaths {
-		if err := genie(llPath, origPath, output); err != nil {
+	inPaths := flag.Args()
+	gen := newHookGen()
+	gen.skip = skip
+	gen.rva = rva || len(module) > 0
+	gen.module = module
+	for _, name := range strings.Split(flagEnums, ",") {
+		if len(name) > 0 {
+			gen.flagEnums[name] = true
+		}
+	}
+	if err := gen.genie(inPaths, origPath, output); err != nil {
+		log.Fatalf("%+v", err)
+	}
+	if len(headerPath) > 0 {
+		if err := gen.header.writeFile(headerPath); err != nil {
 			log.Fatalf("%+v", err)
 		}
 	}
 }
 
-// genie converts the given LLVM IR assembly file into a Go package containing
-// the same exported functions.
-func genie(llPath, origPath, output string) error {
-	m, err := asm.ParseFile(llPath)
+// hookGen holds the state of hook generation, as shared between functions.
+type hookGen struct {
+	// Names of enum types forced to be printed as sets of bit flags.
+	flagEnums map[string]bool
+	// Names of enum print helpers already output.
+	enumsDone map[string]bool
+	// Skip functions using unsupported features, instead of failing.
+	skip bool
+	// C header of type definitions and prototypes of hooked functions.
+	header *headerGen
+	// Resolve addresses of the original executable as RVAs relative to the
+	// base address of the loaded module, and install hooks at runtime.
+	rva bool
+	// Name of module containing the functions to hook (e.g. foo.dll); empty
+	// for the main executable.
+	module string
+	// Names of functions with hooks installed at runtime by
+	// install_hooks_genie.
+	installs []string
+}
+
+// newHookGen returns a new hook generator.
+func newHookGen() *hookGen {
+	return &hookGen{
+		flagEnums: make(map[string]bool),
+		enumsDone: make(map[string]bool),
+		header:    newHeaderGen(),
+	}
+}
+
+// genie converts the given LLVM IR assembly files and C headers into C source
+// code of hooks of the declared functions.
+func (gen *hookGen) genie(inPaths []string, origPath, output string) error {
+	var funcs []*hookedFunc
+	for _, inPath := range inPaths {
+		fns, err := gen.loadFuncs(inPath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		funcs = append(funcs, fns...)
+	}
+	exe, err := openExecutable(origPath)
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	file, err := pe.ParseFile(origPath)
+	a, err := archOf(exe)
 	if err != nil {
 		return errors.WithStack(err)
 	}
+	if err := resolveSyms(funcs, exe); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := checkHooks(funcs, exe, a); err != nil {
+		return errors.WithStack(err)
+	}
 	w := os.Stdout
 	if len(output) > 0 {
 		fd, err := os.Create(output)
@@ -69,88 +168,388 @@ func genie(llPath, origPath, output string) error {
 		defer fd.Close()
 		w = fd
 	}
-	const preface = `
+	// Hooks are installed at runtime in RVA mode and for import address table
+	// hooks.
+	installing := gen.rva
+	for _, fn := range funcs {
+		if fn.imp != nil {
+			installing = true
+		}
+	}
+	if installing {
+		if err := gen.printModule(w, "preface", exe, a); err != nil {
+			return errors.WithStack(err)
+		}
+	} else {
+		const preface = `
 #include "export.h"
 `
-	fmt.Fprintln(w, preface[1:])
+		fmt.Fprintln(w, preface[1:])
+	}
+	for _, fn := range funcs {
+		if err := gen.hookFunc(w, fn, exe, a); err != nil {
+			if e, ok := errors.Cause(err).(*mdutil.UnsupportedError); ok && gen.skip {
+				log.Printf("skipping unsupported function; %v", e)
+				continue
+			}
+			return errors.WithStack(err)
+		}
+	}
+	if installing {
+		if err := gen.printModule(w, "install", exe, a); err != nil {
+			return errors.WithStack(err)
+		}
+		gen.header.addFunc(&ctype.FuncType{RetType: ctype.BasicTypeVoid}, "install_hooks_genie")
+	}
+	return nil
+}
+
+//go:embed module.tmpl
+var moduleTmpl string
+
+// printModule outputs the named template of module.tmpl, writing to w. The
+// templates locate the loaded module containing the functions to hook, and
+// install the hooks at runtime (RVA mode).
+func (gen *hookGen) printModule(w io.Writer, name string, exe executable, a *arch) error {
+	funcs := template.FuncMap{
+		"addr": a.addrString,
+	}
+	t, err := template.New("module.tmpl").Funcs(funcs).Parse(moduleTmpl)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	data := map[string]interface{}{
+		"Windows":   a.Windows,
+		"Module":    gen.module,
+		"ImageBase": exe.ImageBase(),
+		"Funcs":     gen.installs,
+	}
+	if err := t.ExecuteTemplate(w, name, data); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// hookedFunc is a function of the original executable to hook, as declared by
+// a stub function in LLVM IR or by an annotated C prototype.
+type hookedFunc struct {
+	// Function name.
+	name string
+	// Function address; set by resolveSyms if located by symbol name.
+	addr uint64
+	// Symbol name of function exported by the original executable; or empty
+	// if located by address.
+	sym string
+	// Imported function hooked through its import address table slot; or nil
+	// if hooked by patching the function.
+	imp *importRef
+	// Virtual address of import address table slot; set by resolveSyms if imp
+	// is set.
+	slot uint64
+	// Function type, including parameter names.
+	typ *ctype.FuncType
+	// User calling convention; or nil if not annotated.
+	userCall *userCall
+	// Size of patch in number of bytes; set by checkHooks.
+	patchSize int64
+}
+
+// loadFuncs returns the functions to hook declared by the given LLVM IR
+// assembly file or C header (*.h). Functions using unsupported features are
+// skipped if gen.skip is set.
+func (gen *hookGen) loadFuncs(inPath string) ([]*hookedFunc, error) {
+	if isCHeader(inPath) {
+		protos, err := cparse.ParseFile(inPath)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		var funcs []*hookedFunc
+		for _, proto := range protos {
+			fn, err := funcFromProto(proto)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			funcs = append(funcs, fn)
+		}
+		return funcs, nil
+	}
+	m, err := mdutil.ParseFile(inPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var funcs []*hookedFunc
 	for _, f := range m.Funcs {
 		if len(f.Blocks) == 0 {
 			continue
 		}
-		locals := mdutil.LocalVars(f)
-		addr, err := parseAddr(f, locals)
+		fn, err := funcFromIR(f)
 		if err != nil {
-			return errors.WithStack(err)
+			if e, ok := errors.Cause(err).(*mdutil.UnsupportedError); ok && gen.skip {
+				log.Printf("skipping unsupported function; %v", e)
+				continue
+			}
+			return nil, errors.WithStack(err)
 		}
-		if err := printFunc(w, f, addr, locals, file); err != nil {
-			return errors.WithStack(err)
+		funcs = append(funcs, fn)
+	}
+	return funcs, nil
+}
+
+// isCHeader reports whether the given input file is a C header, as opposed to
+// an LLVM IR assembly file.
+func isCHeader(inPath string) bool {
+	return filepath.Ext(inPath) == ".h"
+}
+
+// funcFromProto returns the function to hook declared by the given annotated
+// C prototype. Unnamed parameters are named after their position (e.g.
+// "arg1").
+func funcFromProto(proto *cparse.Func) (*hookedFunc, error) {
+	funcType := *proto.Type
+	funcType.ParamNames = make([]string, len(funcType.ParamTypes))
+	for i := range funcType.ParamTypes {
+		if i < len(proto.Type.ParamNames) && len(proto.Type.ParamNames[i]) > 0 {
+			funcType.ParamNames[i] = proto.Type.ParamNames[i]
+		} else {
+			funcType.ParamNames[i] = fmt.Sprintf("arg%d", i+1)
 		}
 	}
-	return nil
+	fn, err := targetFromProto(proto)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	fn.typ = &funcType
+	return fn, nil
 }
 
-//go:embed export.tmpl
-var exportTmpl string
+// targetFromProto returns a function to hook with the name and hook target set,
+// as annotated by the given C prototype.
+func targetFromProto(proto *cparse.Func) (*hookedFunc, error) {
+	fn := &hookedFunc{name: proto.Name, addr: proto.Addr, sym: proto.Sym}
+	if len(proto.Import) > 0 {
+		imp, err := parseImportRef(proto.Import)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid iat annotation of function %q", proto.Name)
+		}
+		fn.imp = imp
+	}
+	return fn, nil
+}
 
-// printFunc outputs the given function in Go syntax, writing to w. The content
-// of the original binary executable is used for patches (restoring the original
-// assembly instructions that were overwritten by the injected jmp instruction).
-func printFunc(w io.Writer, f *ir.Func, addr uint64, locals []mdutil.Var, file *pe.File) error {
-	// Get return type.
+// funcFromIR returns the function to hook declared by the given stub function
+// in LLVM IR, as based on its debug information and the address (or symbol
+// name, or imported function) and calling convention annotations stored in its
+// local variables.
+func funcFromIR(f *ir.Func) (*hookedFunc, error) {
+	locals, err := mdutil.LocalVars(f)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	fn, err := parseTarget(f, locals)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 	m := make(map[string]mdutil.Var)
 	for _, local := range locals {
 		m[local.LLVarName] = local
 	}
+
+	// Get return type.
 	retType, err := parseRetType(f)
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
 
 	// Get calling convention.
-	callConv := cCallConv(f.CallingConv)
+	callConv, err := cCallConv(f)
+	if err != nil {
+		return nil, withFuncName(err, f.Name())
+	}
 
-	// Get function name.
-	funcName := f.Name()
+	// Get user calling convention, if annotated.
+	userCall, err := parseUserCall(f, locals)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 
-	// Get params.
-	var params []mdutil.Var
+	// Get function type.
+	funcType := &ctype.FuncType{
+		RetType:  retType,
+		CallConv: callConv,
+	}
 	for _, param := range f.Params {
 		// Look for store instructions in the entry basic block, used to store
 		// function paramters in stack-allocated local variables.
 		localName, err := findParamName(f, param)
 		if err != nil {
-			return errors.WithStack(err)
+			return nil, errors.WithStack(err)
 		}
 		local, ok := m[localName]
 		if !ok {
-			panic(fmt.Errorf("unable to locate debug info of local %q in function %q", localName, f.Name()))
+			return nil, errors.Errorf("unable to locate debug info of local %q in function %q", localName, f.Name())
+		}
+		funcType.ParamTypes = append(funcType.ParamTypes, local.CType)
+		funcType.ParamNames = append(funcType.ParamNames, local.CVarName)
+	}
+	fn.typ = funcType
+	fn.userCall = userCall
+	return fn, nil
+}
+
+// hookFunc outputs the hook of the given function, writing to w. Nothing is
+// written if an error occurs.
+func (gen *hookGen) hookFunc(w io.Writer, fn *hookedFunc, exe executable, a *arch) error {
+	buf := &bytes.Buffer{}
+	if err := gen.printFunc(buf, fn, exe, a); err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := buf.WriteTo(w); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+//go:embed export.tmpl
+var exportTmpl string
+
+// printFunc outputs the C hook of the given function, writing to w. The content
+// of the original binary executable is used for the trampoline (executing the
+// original assembly instructions that were overwritten by the injected jmp
+// instruction, rounded up to whole instructions), the size of which depends on
+// the architecture a. Print helpers are output for enum types used by the function,
+// unless already output for a previous function.
+func (gen *hookGen) printFunc(w io.Writer, fn *hookedFunc, exe executable, a *arch) error {
+	funcName, addr, retType := fn.name, fn.addr, fn.typ.RetType
+
+	// Get calling convention.
+	callConv := a.callConv(fn.typ.CallConv)
+
+	// The hook function of user calling convention functions is called from an
+	// entry thunk using the default calling convention.
+	userCall := fn.userCall
+	hookName := funcName
+	if userCall != nil {
+		if a.is64() {
+			return withFuncName(unsupported("__%s calling convention on %v", userCall.Keyword, a.Machine), funcName)
+		}
+		if callConv != 0 {
+			return errors.Errorf("conflicting calling conventions %v and __%s of function %q", callConv, userCall.Keyword, funcName)
+		}
+		if fn.imp != nil {
+			return errors.Errorf("conflicting __%s calling convention and import address table hook of function %q", userCall.Keyword, funcName)
+		}
+		hookName = funcName + "_usercall_genie"
+	}
+
+	// Get function type and params.
+	funcType := *fn.typ
+	funcType.CallConv = callConv
+	var params []mdutil.Var
+	for i, paramType := range funcType.ParamTypes {
+		param := mdutil.Var{
+			CVarName: funcType.ParamNames[i],
+			CType:    paramType,
+		}
+		params = append(params, param)
+	}
+
+	// Get print statements of params and return value.
+	var paramPrints []string
+	for _, param := range params {
+		paramPrint, err := printArg(param.CVarName, param.CVarName, param.CType)
+		if err != nil {
+			return withFuncName(err, funcName)
+		}
+		paramPrints = append(paramPrints, paramPrint)
+	}
+	var retPrint string
+	if !isVoid(retType) {
+		var err error
+		retPrint, err = printArg(fmt.Sprintf("ret (%s)", funcName), "ret_genie", retType)
+		if err != nil {
+			return withFuncName(err, funcName)
+		}
+	}
+
+	// Get enum types requiring print helpers.
+	var enums []*enumPrinter
+	enumsDone := make(map[string]bool)
+	types := []ctype.Type{retType}
+	for _, param := range params {
+		types = append(types, param.CType)
+	}
+	for _, t := range types {
+		for _, e := range collectEnums(t, nil) {
+			name := enumPrinterName(e.typ)
+			if gen.enumsDone[name] || enumsDone[name] {
+				continue
+			}
+			enumsDone[name] = true
+			flags := e.typ.Flags || gen.isFlagEnum(e)
+			enums = append(enums, newEnumPrinter(e.typ, flags))
 		}
-		params = append(params, local)
 	}
 
 	// Output using template.
 	funcs := template.FuncMap{
-		"verb":            verbFromCType,
-		"typeIdentString": typeIdentString,
+		"mask": maskString,
+		"decl": ctype.Decl,
+		"addr": a.addrString,
 	}
 	const tmplName = "export.tmpl"
 	t, err := template.New(tmplName).Funcs(funcs).Parse(exportTmpl)
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	// Size of injected jmp instruction in number of bytes.
-	const patchSize = 5
-	orig := file.ReadData(addr, patchSize)
+	// Imported functions are called through the original pointer of their
+	// import address table slot, rather than a trampoline.
+	var (
+		trampoline []string
+		tab        *addrTable
+	)
+	if fn.imp == nil {
+		prologue, err := exe.ReadAt(addr, fn.patchSize)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		// In RVA mode, addresses of the original executable referenced by the
+		// trampoline are resolved at runtime.
+		if gen.rva {
+			if err := a.checkRelocs(exe, addr, fn.patchSize); err != nil {
+				return errors.Wrapf(err, "unable to generate ASLR-aware hook of function %q", funcName)
+			}
+			tab = a.newAddrTable(funcName+"_addrs_genie", exe)
+		}
+		trampoline, err = a.trampolineAsm(prologue, addr, tab)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
 	tw := tabwriter.NewWriter(w, 1, 3, 1, ' ', tabwriter.TabIndent)
 	data := map[string]interface{}{
-		"RetType":   retType,
-		"CallConv":  callConv,
-		"FuncName":  funcName,
-		"Params":    params,
-		"Orig":      orig,
-		"PatchSize": patchSize,
-		"Addr":      addr,
+		"RetType":     retType,
+		"FuncType":    &funcType,
+		"FuncPtr":     &ctype.PointerType{Elem: &funcType},
+		"FuncName":    funcName,
+		"HookName":    hookName,
+		"UserCall":    userCall,
+		"Params":      params,
+		"ParamPrints": paramPrints,
+		"RetPrint":    retPrint,
+		"Trampoline":  trampoline,
+		"Addr":        addr,
+		"RVA":         addr - exe.ImageBase(),
+		"AddrTable":   tab,
+		"Import":      fn.imp,
+		"SlotRVA":     fn.slot - exe.ImageBase(),
+		"Is64":        a.is64(),
+		"Enums":       enums,
 	}
+	// Hooks of ELF executables located by symbol name are hidden, as hooks
+	// named after functions exported by the executable would otherwise be
+	// interposed by the exported function; thus hooking the function with
+	// itself.
+	data["Hidden"] = !a.Windows && len(fn.sym) > 0
 	if !isVoid(retType) {
 		retParam := mdutil.Var{
 			CVarName: "ret",
@@ -164,6 +563,21 @@ func printFunc(w io.Writer, f *ir.Func, addr uint64, locals []mdutil.Var, file *
 	if err := tw.Flush(); err != nil {
 		return errors.WithStack(err)
 	}
+	// Mark enum print helpers as output and add the hook to the C header only
+	// after the hook has been generated successfully.
+	for name := range enumsDone {
+		gen.enumsDone[name] = true
+	}
+	gen.header.addFunc(&funcType, hookName)
+	if userCall != nil {
+		entryType := &ctype.FuncType{RetType: ctype.BasicTypeVoid}
+		gen.header.addFunc(entryType, funcName)
+	}
+	if tab != nil || fn.imp != nil {
+		installType := &ctype.FuncType{RetType: ctype.BasicTypeInt}
+		gen.header.addFunc(installType, "install_"+funcName+"_genie")
+		gen.installs = append(gen.installs, funcName)
+	}
 	return nil
 }
 
@@ -173,59 +587,324 @@ func isVoid(t ctype.Type) bool {
 	return ok && tt == ctype.BasicTypeVoid
 }
 
-// typeIdentString returns the C string representation of the given type and
-// identifier pair.
-func typeIdentString(t ctype.Type, varName string) string {
+// printArg returns the C statements printing the value of the given C
+// expression of the specified type, using label to identify the value in the
+// output. Structure values are printed field by field.
+func printArg(label, expr string, t ctype.Type) (string, error) {
+	buf := &strings.Builder{}
+	if err := writeArg(buf, label, expr, t); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n\t"), nil
+}
+
+// writeArg writes the C statements printing the value of the given C
+// expression of the specified type to buf, each followed by a line break and
+// indentation.
+func writeArg(buf *strings.Builder, label, expr string, t ctype.Type) error {
+	switch tt := underlying(t).(type) {
+	case *ctype.EnumType:
+		if len(tt.Enumerators) == 0 {
+			break
+		}
+		fmt.Fprintf(buf, "printf(\"\\t%s: \"); %s(%s); printf(\"\\n\");\n\t", label, enumPrinterName(tt), expr)
+		return nil
+	case *ctype.StructType, *ctype.UnionType:
+		// All fields of unions are printed, as any one of the overlapping
+		// interpretations may be the active one.
+		fields := fieldsOf(tt)
+		if len(fields) == 0 {
+			fmt.Fprintf(buf, "printf(\"\\t%s: {}\\n\");\n\t", label)
+			return nil
+		}
+		for _, field := range fields {
+			// Fields of anonymous structures and unions are accessed directly
+			// through the enclosing value.
+			fieldLabel, fieldExpr := label, expr
+			if len(field.Name) > 0 {
+				fieldLabel = label + "." + field.Name
+				fieldExpr = expr + "." + field.Name
+			}
+			if err := writeArg(buf, fieldLabel, fieldExpr, field.Typ); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if tt, ok := underlying(t).(ctype.BasicType); ok {
+		switch tt {
+		case ctype.BasicTypeInt128, ctype.BasicTypeSInt128, ctype.BasicTypeUInt128:
+			// printf has no conversion specifier for 128-bit integers; print
+			// the high and low 64 bits in hexadecimal.
+			fmt.Fprintf(buf, "printf(\"\\t%s: 0x%%016llX%%016llX\\n\", (unsigned long long)((unsigned __int128)%s >> 64), (unsigned long long)%s);\n\t", label, expr, expr)
+			return nil
+		case ctype.BasicTypeFloatComplex, ctype.BasicTypeDoubleComplex, ctype.BasicTypeLongDoubleComplex:
+			fmt.Fprintf(buf, "printf(\"\\t%s: %%Lf%%+Lfi\\n\", (long double)__real__ %s, (long double)__imag__ %s);\n\t", label, expr, expr)
+			return nil
+		}
+	}
+	verb, err := verbFromCType(t)
+	if err != nil {
+		if e, ok := err.(*mdutil.UnsupportedError); ok {
+			e.VarName = label
+		}
+		return err
+	}
+	fmt.Fprintf(buf, "printf(\"\\t%s: %s\\n\", %s);\n\t", label, verb, expr)
+	return nil
+}
+
+// withFuncName records the given function name in unsupported feature errors,
+// and returns err.
+func withFuncName(err error, funcName string) error {
+	if e, ok := err.(*mdutil.UnsupportedError); ok {
+		e.FuncName = funcName
+	}
+	return err
+}
+
+// unsupported returns a new error reporting an unsupported feature not tied to
+// a metadata node, described by the given format specifier and arguments.
+func unsupported(format string, a ...interface{}) error {
+	return &mdutil.UnsupportedError{
+		NodeID: -1,
+		Msg:    fmt.Sprintf(format, a...),
+	}
+}
+
+// enumPrinter is the print helper of an enum type, which outputs the symbolic
+// name of enum values.
+type enumPrinter struct {
+	// Name of print helper function.
+	Name string
+	// Enum values are sets of bit flags.
+	Flags bool
+	// Bit mask of the enum values, as wide as the enum type; or zero if as wide
+	// as long long. Used to strip the sign extension of negative flag values.
+	Mask int64
+	// Enumerators with unique values, used as switch cases. For sets of bit
+	// flags, the non-zero enumerators ordered by decreasing number of bits set.
+	Cases []*ctype.Enumerator
+	// Enumerator with value zero of sets of bit flags; or nil if not present.
+	Zero *ctype.Enumerator
+}
+
+// newEnumPrinter returns a new print helper for the given enum type, printing
+// enum values as sets of bit flags if flags is set.
+func newEnumPrinter(t *ctype.EnumType, flags bool) *enumPrinter {
+	p := &enumPrinter{
+		Name:  enumPrinterName(t),
+		Flags: flags,
+	}
+	if enumSize(t) < 8 {
+		p.Mask = math.MaxUint32
+	}
+	// Enumerators may share values; use the first name declared for each value
+	// to prevent duplicate case labels.
+	values := make(map[int64]bool)
+	for _, enumerator := range t.Enumerators {
+		if values[enumerator.Value] {
+			continue
+		}
+		values[enumerator.Value] = true
+		if flags && enumerator.Value == 0 {
+			p.Zero = enumerator
+			continue
+		}
+		p.Cases = append(p.Cases, enumerator)
+	}
+	if flags {
+		// Output combinations of flags before the individual flags they contain.
+		sort.SliceStable(p.Cases, func(i, j int) bool {
+			return bits.OnesCount64(uint64(p.Cases[i].Value)) > bits.OnesCount64(uint64(p.Cases[j].Value))
+		})
+	}
+	return p
+}
+
+// enumPrinterName returns the name of the print helper function of the given
+// enum type.
+func enumPrinterName(t *ctype.EnumType) string {
+	name := t.Name
+	if len(name) == 0 && len(t.Enumerators) > 0 {
+		// Enumerator names are unique within scope, and thus identify anonymous
+		// enums.
+		name = t.Enumerators[0].Name
+	}
+	return fmt.Sprintf("print_enum_%s_genie", name)
+}
+
+// enumSize returns the size in bytes of the given enum type. As laid out by
+// GCC, enum types are int or unsigned int wide, unless their enumerator values
+// do not fit in 32 bits.
+func enumSize(t *ctype.EnumType) int {
+	var min, max int64
+	for _, enumerator := range t.Enumerators {
+		if enumerator.Value < min {
+			min = enumerator.Value
+		}
+		if enumerator.Value > max {
+			max = enumerator.Value
+		}
+	}
+	switch {
+	case min >= 0 && max <= math.MaxUint32:
+		return 4
+	case min >= math.MinInt32 && max <= math.MaxInt32:
+		return 4
+	default:
+		return 8
+	}
+}
+
+// maskString returns the C unsigned long long literal of the given bit mask.
+func maskString(mask int64) string {
+	return fmt.Sprintf("0x%XULL", uint64(mask))
+}
+
+// namedEnum is an enum type printed by value, as referenced through type
+// definitions.
+type namedEnum struct {
+	// Enum type.
+	typ *ctype.EnumType
+	// Names of type definitions of the enum type (e.g. "foo_t" of
+	// "typedef enum {...} foo_t"), outermost first.
+	typedefs []string
+}
+
+// isFlagEnum reports whether the given enum type is forced to be printed as a
+// set of bit flags (see -flagenums), as identified by its tag or the name of
+// one of its type definitions.
+func (gen *hookGen) isFlagEnum(e *namedEnum) bool {
+	if len(e.typ.Name) > 0 && gen.flagEnums[e.typ.Name] {
+		return true
+	}
+	for _, name := range e.typedefs {
+		if gen.flagEnums[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// collectEnums appends the enum types with enumerators printed by value as part
+// of the given type to enums, and returns the extended slice.
+func collectEnums(t ctype.Type, enums []*namedEnum) []*namedEnum {
+	// Skip type definitions and type qualifiers, recording the names of type
+	// definitions.
+	var typedefs []string
+	for {
+		if tt, ok := t.(*ctype.Typedef); ok {
+			typedefs = append(typedefs, tt.Name)
+			t = tt.Typ
+		} else if tt, ok := t.(*ctype.ConstType); ok {
+			t = tt.Typ
+		} else {
+			break
+		}
+	}
 	switch t := t.(type) {
-	case *ctype.PointerType:
-		if funcType, ok := t.Elem.(*ctype.FuncType); ok {
-			funcType.Callee = varName
-			return funcType.String()
+	case *ctype.EnumType:
+		if len(t.Enumerators) > 0 {
+			enums = append(enums, &namedEnum{typ: t, typedefs: typedefs})
+		}
+	case *ctype.StructType, *ctype.UnionType:
+		for _, field := range fieldsOf(t) {
+			enums = collectEnums(field.Typ, enums)
+		}
+	}
+	return enums
+}
+
+// fieldsOf returns the fields of the given structure or union type.
+func fieldsOf(t ctype.Type) []*ctype.Field {
+	switch t := t.(type) {
+	case *ctype.StructType:
+		return t.Fields
+	case *ctype.UnionType:
+		return t.Fields
+	default:
+		return nil
+	}
+}
+
+// isChar reports whether the given type is a (possibly qualified or type
+// defined) char type.
+func isChar(t ctype.Type) bool {
+	tt, ok := underlying(t).(ctype.BasicType)
+	return ok && tt == ctype.BasicTypeChar
+}
+
+// underlying returns the underlying type of the given type, skipping type
+// definitions and type qualifiers.
+func underlying(t ctype.Type) ctype.Type {
+	for {
+		switch tt := t.(type) {
+		case *ctype.Typedef:
+			t = tt.Typ
+		case *ctype.ConstType:
+			t = tt.Typ
+		default:
+			return t
 		}
 	}
-	return fmt.Sprintf("%s %s", t, varName)
 }
 
 // verbFromCType returns the format string verb corresponding to the given C
 // type.
-func verbFromCType(t ctype.Type) string {
+func verbFromCType(t ctype.Type) (string, error) {
 	switch t := t.(type) {
 	case ctype.BasicType:
 		switch t {
-		case ctype.BasicTypeChar, ctype.BasicTypeSChar, ctype.BasicTypeUChar, ctype.BasicTypeShort, ctype.BasicTypeShortInt, ctype.BasicTypeSShort, ctype.BasicTypeSShortInt, ctype.BasicTypeUShort, ctype.BasicTypeUShortInt, ctype.BasicTypeInt, ctype.BasicTypeSigned, ctype.BasicTypeSInt, ctype.BasicTypeUnsigned, ctype.BasicTypeUInt, ctype.BasicTypeLong, ctype.BasicTypeLongInt, ctype.BasicTypeSLong, ctype.BasicTypeSLongInt, ctype.BasicTypeULong, ctype.BasicTypeULongInt:
-			return "%d"
-		case ctype.BasicTypeLongLong, ctype.BasicTypeLongLongInt, ctype.BasicTypeSLongLong, ctype.BasicTypeSLongLongInt, ctype.BasicTypeULongLong, ctype.BasicTypeULongLongInt:
-			return "%ld"
-		case ctype.BasicTypeFloat, ctype.BasicTypeDouble, ctype.BasicTypeLongDouble:
-			return "%f"
+		// Types promoted to int.
+		case ctype.BasicTypeChar, ctype.BasicTypeSChar, ctype.BasicTypeUChar, ctype.BasicTypeShort, ctype.BasicTypeShortInt, ctype.BasicTypeSShort, ctype.BasicTypeSShortInt, ctype.BasicTypeUShort, ctype.BasicTypeUShortInt, ctype.BasicTypeInt, ctype.BasicTypeSigned, ctype.BasicTypeSInt, ctype.BasicTypeBool, ctype.BasicTypeWChar, ctype.BasicTypeChar16:
+			return "%d", nil
+		case ctype.BasicTypeUnsigned, ctype.BasicTypeUInt, ctype.BasicTypeChar32:
+			return "%u", nil
+		case ctype.BasicTypeLong, ctype.BasicTypeLongInt, ctype.BasicTypeSLong, ctype.BasicTypeSLongInt:
+			return "%ld", nil
+		case ctype.BasicTypeULong, ctype.BasicTypeULongInt:
+			return "%lu", nil
+		case ctype.BasicTypeLongLong, ctype.BasicTypeLongLongInt, ctype.BasicTypeSLongLong, ctype.BasicTypeSLongLongInt:
+			return "%lld", nil
+		case ctype.BasicTypeULongLong, ctype.BasicTypeULongLongInt:
+			return "%llu", nil
+		case ctype.BasicTypeFloat, ctype.BasicTypeDouble:
+			return "%f", nil
+		case ctype.BasicTypeLongDouble:
+			return "%Lf", nil
 		default:
-			panic(fmt.Errorf("support for basic type %v (%s) not yet implemented", uint(t), t))
+			return "", unsupported("support for basic type %v (%s) not yet implemented", uint(t), t)
 		}
 	case *ctype.PointerType:
 		switch elem := t.Elem.(type) {
 		case ctype.BasicType:
 			if elem == ctype.BasicTypeChar {
-				return "%s"
+				return "%s", nil
 			}
 		case *ctype.ConstType:
 			if e, ok := elem.Typ.(ctype.BasicType); ok && e == ctype.BasicTypeChar {
-				return "%s"
+				return "%s", nil
 			}
 		case *ctype.Typedef:
 			if e, ok := elem.Typ.(ctype.BasicType); ok && e == ctype.BasicTypeChar {
-				return "%s"
+				return "%s", nil
 			}
 		}
-		return "%p"
+		return "%p", nil
+	case *ctype.ArrayType:
+		if isChar(t.Elem) {
+			return "%s", nil
+		}
+		return "%p", nil
 	case *ctype.EnumType:
-		return "%d"
-	case *ctype.StructType:
-		// TODO: add better support for struct types.
-		return "%p"
+		return "%d", nil
+	case *ctype.ConstType:
+		return verbFromCType(t.Typ)
 	case *ctype.Typedef:
 		return verbFromCType(t.Typ)
 	default:
-		panic(fmt.Errorf("support for type %T not yet implemented", t))
+		return "", unsupported("support for type %T not yet implemented", t)
 	}
 }
 
@@ -257,41 +936,165 @@ func findParamName(f *ir.Func, param *ir.Param) (string, error) {
 	return "", errors.Errorf("unable to locate name of stack-allocated local variable corresponding to function parameter %q in function %q", param.Name(), f.Name())
 }
 
-// cCallConv returns the C calling convention corresponding to the given LLVM IR
-// calling convention.
-func cCallConv(callConv enum.CallingConv) string {
-	switch callConv {
+// cCallConv returns the C calling convention corresponding to the LLVM IR
+// calling convention of the given function.
+func cCallConv(f *ir.Func) (ctype.CallingConv, error) {
+	switch f.CallingConv {
 	case enum.CallingConvNone:
-		return ""
+		return regParmCallConv(f), nil
+	case enum.CallingConvC:
+		if callConv := regParmCallConv(f); callConv != 0 {
+			return callConv, nil
+		}
+		return ctype.CallConvCDecl, nil
 	case enum.CallingConvX86StdCall:
-		return "__stdcall"
+		return ctype.CallConvStdCall, nil
 	case enum.CallingConvX86FastCall:
-		return "__fastcall"
+		return ctype.CallConvFastCall, nil
+	case enum.CallingConvX86ThisCall:
+		return ctype.CallConvThisCall, nil
+	case enum.CallingConvX86VectorCall:
+		return ctype.CallConvVectorCall, nil
+	case enum.CallingConvWin64:
+		return ctype.CallConvMSABI, nil
+	case enum.CallingConvX86_64SysV:
+		return ctype.CallConvSysVABI, nil
+	default:
+		return 0, unsupported("support for calling convention %v not yet implemented", f.CallingConv)
+	}
+}
+
+// regParmCallConv returns the regparm calling convention of the given C calling
+// convention function, as based on the number of registers used by leading
+// parameters passed in registers (inreg); or zero if no parameters are passed
+// in registers.
+func regParmCallConv(f *ir.Func) ctype.CallingConv {
+	n := 0
+	for _, param := range f.Params {
+		if !hasParamAttr(param, enum.ParamAttrInReg) {
+			break
+		}
+		// 64-bit integer parameters occupy two registers.
+		if t, ok := param.Typ.(*types.IntType); ok && t.BitSize > 32 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	switch {
+	case n == 0:
+		return 0
+	case n == 1:
+		return ctype.CallConvRegParm1
+	case n == 2:
+		return ctype.CallConvRegParm2
 	default:
-		panic(fmt.Errorf("support for calling convention %v not yet implemented", callConv))
+		return ctype.CallConvRegParm3
+	}
+}
+
+// hasParamAttr reports whether the given parameter has the specified attribute.
+func hasParamAttr(param *ir.Param, attr enum.ParamAttr) bool {
+	for _, a := range param.Attrs {
+		if a, ok := a.(enum.ParamAttr); ok && a == attr {
+			return true
+		}
 	}
+	return false
 }
 
-// parseAddr parses the address of the given function. The address is stored in
-// the 'addr' variable.
-func parseAddr(f *ir.Func, locals []mdutil.Var) (uint64, error) {
+// parseTarget parses the hook target of the given function, returning a
+// function to hook with the name and target set. The address is stored in the
+// 'addr' variable. Alternatively, the symbol name of a function exported by the
+// original executable (or an ordinal of the form "#N") is stored in the
+// 'addr_sym' string variable, and the address is resolved by resolveSyms; or
+// an imported function of the form "DLL!NAME" is stored in the 'iat' string
+// variable, to hook its import address table slot (see importRef).
+func parseTarget(f *ir.Func, locals []mdutil.Var) (*hookedFunc, error) {
+	fn := &hookedFunc{name: f.Name()}
+	switch {
+	case hasLocal(locals, "iat"):
+		s, err := parseString(f, locals, "iat")
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		imp, err := parseImportRef(s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid iat annotation of function %q", f.Name())
+		}
+		fn.imp = imp
+	case hasLocal(locals, "addr_sym"):
+		sym, err := parseString(f, locals, "addr_sym")
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if len(sym) == 0 {
+			return nil, errors.Errorf("empty addr_sym of function %q", f.Name())
+		}
+		fn.sym = sym
+	default:
+		src, err := findLocalStore(f, locals, "addr")
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		v, ok := src.(*constant.Int)
+		if !ok {
+			return nil, errors.Errorf("addr constant type mismatch; expected *constant.Int, got %T", src)
+		}
+		fn.addr = v.X.Uint64()
+	}
+	return fn, nil
+}
+
+// parseString parses the string constant stored in the C local variable with
+// the given name of the specified function.
+func parseString(f *ir.Func, locals []mdutil.Var, cVarName string) (string, error) {
+	src, err := findLocalStore(f, locals, cVarName)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	// Locate global variable of string constant; either referenced directly
+	// or through a getelementptr constant expression.
+	if expr, ok := src.(*constant.ExprGetElementPtr); ok {
+		src = expr.Src
+	}
+	if g, ok := src.(*ir.Global); ok {
+		if v, ok := g.Init.(*constant.CharArray); ok {
+			return strings.TrimRight(string(v.X), "\x00"), nil
+		}
+	}
+	return "", errors.Errorf("%s string constant type mismatch; expected pointer to *constant.CharArray, got %v", cVarName, src)
+}
+
+// hasLocal reports whether the given C local variable is present.
+func hasLocal(locals []mdutil.Var, cVarName string) bool {
+	for _, local := range locals {
+		if local.CVarName == cVarName {
+			return true
+		}
+	}
+	return false
+}
+
+// findLocalStore returns the value stored to the C local variable with the
+// given name in the entry basic block of the specified function.
+func findLocalStore(f *ir.Func, locals []mdutil.Var, cVarName string) (value.Value, error) {
 	if len(f.Blocks) != 1 {
-		return 0, errors.Errorf("invalid number of basic blocks in %q; expected 1, got %d", f.Name(), len(f.Blocks))
+		return nil, errors.Errorf("invalid number of basic blocks in %q; expected 1, got %d", f.Name(), len(f.Blocks))
 	}
 	entry := f.Blocks[0]
-	// Locate LLVM IR local variable corresponding to the C variable `addr`.
+	// Locate LLVM IR local variable corresponding to the C variable.
 	// maps from C variable name to LLVM IR variable.
 	cNameToVar := make(map[string]mdutil.Var)
 	for _, local := range locals {
 		cNameToVar[local.CVarName] = local
 	}
-	addrLocal, ok := cNameToVar["addr"]
+	local, ok := cNameToVar[cVarName]
 	if !ok {
-		return 0, errors.Errorf("unable to locate LLVM IR local variable corresponding to C variable `addr` in function %q", f.Name())
+		return nil, errors.Errorf("unable to locate LLVM IR local variable corresponding to C variable `%s` in function %q", cVarName, f.Name())
 	}
-	varName := addrLocal.LLVarName
-	// Locate store instruction, storing the function address to the `addr`
-	// variable.
+	varName := local.LLVarName
+	// Locate store instruction, storing to the local variable.
 	for _, inst := range entry.Insts {
 		storeInst, ok := inst.(*ir.InstStore)
 		if !ok {
@@ -304,16 +1107,9 @@ func parseAddr(f *ir.Func, locals []mdutil.Var) (uint64, error) {
 		if dst.Name() != varName {
 			continue
 		}
-		// Store instruction located, which stores the function address to the
-		// `addr` variable.
-		v, ok := storeInst.Src.(*constant.Int)
-		if !ok {
-			return 0, errors.Errorf("addr constant type mismatch; expected *constant.Int, got %T", storeInst.Src)
-		}
-		addr := v.X.Uint64()
-		return addr, nil
+		return storeInst.Src, nil
 	}
-	return 0, errors.Errorf("unable to locate `store` instruction of `addr` variable in function %q", f.Name())
+	return nil, errors.Errorf("unable to locate `store` instruction of `%s` variable in function %q", cVarName, f.Name())
 }
 
 // parseRetType parses the return type of a given function based on its attached
@@ -329,14 +1125,14 @@ func parseRetType(f *ir.Func) (ctype.Type, error) {
 			continue
 		}
 		// Parse return type.
-		switch field := diSubType.Types.Fields[0].(type) {
-		case *metadata.NullLit:
-			return ctype.BasicTypeVoid, nil
-		case metadata.Field:
-			return mdutil.TypeFromField(field), nil
-		default:
-			panic(fmt.Errorf("support for metadata field type %T not yet implemented", field))
+		if diSubType.Types == nil || len(diSubType.Types.Fields) == 0 {
+			return nil, errors.Errorf("unable to locate return type of function %q", f.Name())
+		}
+		retType, err := mdutil.TypeFromField(diSubType.Types.Fields[0])
+		if err != nil {
+			return nil, withFuncName(err, f.Name())
 		}
+		return retType, nil
 	}
 	return nil, errors.Errorf("unable to locate return type of function %q", f.Name())
 }
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/mewmew/genie/ctype"
+	"github.com/mewmew/genie/mdutil"
+	"github.com/pkg/errors"
+)
+
+func TestPrintArg(t *testing.T) {
+	point := &ctype.StructType{
+		Name: "point",
+		Fields: []*ctype.Field{
+			{Name: "x", Typ: ctype.BasicTypeInt},
+			{Name: "y", Typ: ctype.BasicTypeInt, BitOffset: 32},
+		},
+	}
+	golden := []struct {
+		label, expr string
+		typ         ctype.Type
+		want        string
+	}{
+		{
+			label: "x", expr: "x", typ: ctype.BasicTypeInt,
+			want: `printf("\tx: %d\n", x);`,
+		},
+		{
+			label: "n", expr: "n", typ: ctype.BasicTypeULongLongInt,
+			want: `printf("\tn: %llu\n", n);`,
+		},
+		{
+			label: "d", expr: "d", typ: ctype.BasicTypeLongDouble,
+			want: `printf("\td: %Lf\n", d);`,
+		},
+		{
+			label: "x", expr: "x", typ: ctype.BasicTypeUInt128,
+			want: `printf("\tx: 0x%016llX%016llX\n", (unsigned long long)((unsigned __int128)x >> 64), (unsigned long long)x);`,
+		},
+		{
+			label: "z", expr: "z", typ: ctype.BasicTypeDoubleComplex,
+			want: `printf("\tz: %Lf%+Lfi\n", (long double)__real__ z, (long double)__imag__ z);`,
+		},
+		{
+			label: "p", expr: "p", typ: point,
+			want: `printf("\tp.x: %d\n", p.x);` + "\n\t" + `printf("\tp.y: %d\n", p.y);`,
+		},
+		// struct { struct point; const char *name; }
+		{
+			label: "s", expr: "s",
+			typ: &ctype.Typedef{
+				Name: "s_t",
+				Typ: &ctype.StructType{
+					Fields: []*ctype.Field{
+						{Typ: point},
+						{Name: "name", Typ: &ctype.PointerType{Elem: &ctype.ConstType{Typ: ctype.BasicTypeChar}}, BitOffset: 64},
+					},
+				},
+			},
+			want: `printf("\ts.x: %d\n", s.x);` + "\n\t" + `printf("\ts.y: %d\n", s.y);` + "\n\t" + `printf("\ts.name: %s\n", s.name);`,
+		},
+		{
+			label: "c", expr: "c", typ: &ctype.EnumType{Name: "color", Enumerators: []*ctype.Enumerator{{Name: "RED", Value: 0}}},
+			want: `printf("\tc: "); print_enum_color_genie(c); printf("\n");`,
+		},
+		// Enums without enumerators are printed by value.
+		{
+			label: "o", expr: "o", typ: &ctype.EnumType{Name: "opaque"},
+			want: `printf("\to: %d\n", o);`,
+		},
+		{
+			label: "s", expr: "s", typ: &ctype.ArrayType{Elem: ctype.BasicTypeChar, Len: 16},
+			want: `printf("\ts: %s\n", s);`,
+		},
+		{
+			label: "a", expr: "a", typ: &ctype.ArrayType{Elem: ctype.BasicTypeInt, Len: 16},
+			want: `printf("\ta: %p\n", a);`,
+		},
+		// All fields of unions are printed.
+		{
+			label: "v", expr: "v",
+			typ: &ctype.UnionType{
+				Name: "val",
+				Fields: []*ctype.Field{
+					{Name: "i", Typ: ctype.BasicTypeInt},
+					{Name: "f", Typ: ctype.BasicTypeFloat},
+				},
+			},
+			want: `printf("\tv.i: %d\n", v.i);` + "\n\t" + `printf("\tv.f: %f\n", v.f);`,
+		},
+		{
+			label: "e", expr: "e", typ: &ctype.StructType{Name: "empty"},
+			want: `printf("\te: {}\n");`,
+		},
+	}
+	for _, g := range golden {
+		got, err := printArg(g.label, g.expr, g.typ)
+		if err != nil {
+			t.Errorf("%s: %+v", g.label, err)
+			continue
+		}
+		if got != g.want {
+			t.Errorf("%s: print statements mismatch; expected %q, got %q", g.label, g.want, got)
+		}
+	}
+}
+
+func TestPrintArgUnsupported(t *testing.T) {
+	golden := []struct {
+		label string
+		typ   ctype.Type
+	}{
+		{label: "f", typ: &ctype.FuncType{RetType: ctype.BasicTypeVoid}},
+		{label: "td", typ: &ctype.Typedef{Name: "fn_t", Typ: &ctype.FuncType{RetType: ctype.BasicTypeInt}}},
+	}
+	for _, g := range golden {
+		_, err := printArg(g.label, g.label, g.typ)
+		if _, ok := errors.Cause(err).(*mdutil.UnsupportedError); !ok {
+			t.Errorf("%s: expected *mdutil.UnsupportedError, got %T (%v)", g.label, err, err)
+		}
+	}
+}
+
+func TestCCallConv(t *testing.T) {
+	// newParam returns a new parameter of the given type, optionally passed in
+	// registers.
+	newParam := func(typ types.Type, inreg bool) *ir.Param {
+		param := ir.NewParam("", typ)
+		if inreg {
+			param.Attrs = append(param.Attrs, enum.ParamAttrInReg)
+		}
+		return param
+	}
+	golden := []struct {
+		name     string
+		callConv enum.CallingConv
+		params   []*ir.Param
+		want     ctype.CallingConv
+	}{
+		{name: "none", want: 0},
+		{name: "ccc", callConv: enum.CallingConvC, want: ctype.CallConvCDecl},
+		{name: "x86_stdcallcc", callConv: enum.CallingConvX86StdCall, want: ctype.CallConvStdCall},
+		{name: "x86_fastcallcc", callConv: enum.CallingConvX86FastCall, want: ctype.CallConvFastCall},
+		{name: "x86_thiscallcc", callConv: enum.CallingConvX86ThisCall, want: ctype.CallConvThisCall},
+		{name: "x86_vectorcallcc", callConv: enum.CallingConvX86VectorCall, want: ctype.CallConvVectorCall},
+		{name: "win64cc", callConv: enum.CallingConvWin64, want: ctype.CallConvMSABI},
+		{name: "x86_64_sysvcc", callConv: enum.CallingConvX86_64SysV, want: ctype.CallConvSysVABI},
+		{
+			name:   "inreg i32",
+			params: []*ir.Param{newParam(types.I32, true), newParam(types.I32, false)},
+			want:   ctype.CallConvRegParm1,
+		},
+		{
+			name:   "inreg i32 i32",
+			params: []*ir.Param{newParam(types.I32, true), newParam(types.I32, true)},
+			want:   ctype.CallConvRegParm2,
+		},
+		{
+			name:     "ccc inreg i64 i32",
+			callConv: enum.CallingConvC,
+			params:   []*ir.Param{newParam(types.I64, true), newParam(types.I32, true)},
+			want:     ctype.CallConvRegParm3,
+		},
+	}
+	for _, g := range golden {
+		f := ir.NewFunc("f", types.Void, g.params...)
+		f.CallingConv = g.callConv
+		got, err := cCallConv(f)
+		if err != nil {
+			t.Errorf("%s: %+v", g.name, err)
+			continue
+		}
+		if got != g.want {
+			t.Errorf("%s: calling convention mismatch; expected %v, got %v", g.name, g.want, got)
+		}
+	}
+	// Unsupported calling conventions are reported.
+	f := ir.NewFunc("f", types.Void)
+	f.CallingConv = enum.CallingConvGHC
+	if _, err := cCallConv(f); err == nil {
+		t.Errorf("expected error for unsupported calling convention")
+	}
+}
+
+func TestPrintModule(t *testing.T) {
+	golden := []struct {
+		name     string
+		tmpl     string
+		windows  bool
+		module   string
+		installs []string
+		// Expected substrings of output.
+		want []string
+	}{
+		{
+			name:    "Windows main executable",
+			tmpl:    "preface",
+			windows: true,
+			want:    []string{"#include \"export.h\"", "GetModuleHandleA(NULL)", "VirtualProtect("},
+		},
+		{
+			name:    "Windows module",
+			tmpl:    "preface",
+			windows: true,
+			module:  "foo.dll",
+			want:    []string{"GetModuleHandleA(\"foo.dll\")"},
+		},
+		{
+			name:   "Unix module",
+			tmpl:   "preface",
+			module: "libfoo.so",
+			want:   []string{"#define _GNU_SOURCE", "strcmp(name, \"libfoo.so\")", "return bias + 0x400000;", "mprotect("},
+		},
+		{
+			name:     "install",
+			tmpl:     "install",
+			installs: []string{"foo", "bar"},
+			want:     []string{"void install_hooks_genie(void) {\n\tinstall_foo_genie();\n\tinstall_bar_genie();\n}"},
+		},
+		{
+			name:     "install module",
+			tmpl:     "install",
+			module:   "foo.dll",
+			installs: []string{"foo"},
+			want:     []string{"if (module_base_genie() == 0) {", "module %s not loaded; hooks not installed\\n\", \"foo.dll\");", "install_foo_genie();"},
+		},
+	}
+	for _, g := range golden {
+		exe := &elfExecutable{machine: machine386, imageBase: 0x400000}
+		a := &arch{Machine: machine386, Windows: g.windows, JmpSize: 5, AddrDigits: 6}
+		gen := newHookGen()
+		gen.rva = true
+		gen.module = g.module
+		gen.installs = g.installs
+		buf := &bytes.Buffer{}
+		if err := gen.printModule(buf, g.tmpl, exe, a); err != nil {
+			t.Errorf("%s: %+v", g.name, err)
+			continue
+		}
+		got := buf.String()
+		for _, want := range g.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: expected %q in output:\n%s", g.name, want, got)
+			}
+		}
+	}
+}
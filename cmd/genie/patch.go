@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/mewmew/genie/cparse"
+	"github.com/mewmew/genie/mdutil"
+	"github.com/mewmew/pe"
+	"github.com/pkg/errors"
+)
+
+// Name of the section holding the hook DLL in patched executables.
+const hookSectName = ".genie"
+
+// Section flags of the hook section; code, initialized data, executable,
+// readable and writable (as the import address table of the hook DLL is
+// located in the section).
+const hookSectFlags = 0xE0000060
+
+// IMAGE_DLLCHARACTERISTICS_DYNAMIC_BASE flag of optional headers.
+const dynamicBase = 0x0040
+
+func patchUsage(fs *flag.FlagSet) {
+	const use = `
+Usage: genie patch [OPTION]... FILE.{ll,h}...
+
+Patch the original PE executable to call the hooks of the compiled hook DLL,
+for each function of the given LLVM IR files or C headers.
+
+The hook DLL is embedded into a new section of the patched executable, and its
+base relocations and imports are resolved statically. As the hook DLL is not
+loaded by the Windows loader:
+
+  * its entry point (DllMain and C runtime initializers) and TLS callbacks are
+    not run; hooks may thus not rely on initialized global state of the C
+    runtime, constructors or thread-local storage.
+  * the exception table (.pdata) of x86-64 hook DLLs is not registered; hooks
+    may thus not raise or handle exceptions.
+
+The patched executable is loaded at its preferred image base (the
+DYNAMIC_BASE flag is cleared), and its certificate table is removed.
+`
+	fmt.Fprintln(os.Stderr, use[1:])
+	fs.PrintDefaults()
+}
+
+// patchMain is the entry point of the patch subcommand.
+func patchMain(args []string) {
+	var (
+		// Path to original PE binary executable.
+		origPath string
+		// Path to compiled hook DLL.
+		hooksPath string
+		// Output path of patched PE binary executable.
+		output string
+		// Skip functions without hooks.
+		skip bool
+	)
+	fs := flag.NewFlagSet("patch", flag.ExitOnError)
+	fs.StringVar(&origPath, "orig", "orig.exe", "path to original PE binary executable")
+	fs.StringVar(&hooksPath, "hooks", "hooks.dll", "path to compiled hook DLL")
+	fs.StringVar(&output, "o", "patched.exe", "output path of patched PE binary executable")
+	fs.BoolVar(&skip, "skip", false, "skip and report functions not exported by the hook DLL or hooked through the import address table")
+	fs.Usage = func() { patchUsage(fs) }
+	fs.Parse(args)
+	inPaths := fs.Args()
+	if err := patch(origPath, hooksPath, output, inPaths, skip); err != nil {
+		log.Fatalf("%+v", err)
+	}
+}
+
+// patch patches the original PE binary executable to call the hooks of the
+// given hook DLL for each function of the LLVM IR assembly files or C headers,
+// writing the patched executable to output.
+func patch(origPath, hooksPath, output string, inPaths []string, skip bool) error {
+	content, err := ioutil.ReadFile(origPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	file, err := parsePE(content)
+	if err != nil {
+		return errors.Wrapf(err, "unable to parse PE file %q", origPath)
+	}
+	exe, err := newPEExecutable(file)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	a, err := archOf(exe)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	dll, err := loadHookDLL(hooksPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if dll.machine != uint16(file.FileHdr.Machine) {
+		return errors.Errorf("machine type mismatch between %q (0x%04X) and %q (%v)", hooksPath, dll.machine, origPath, file.FileHdr.Machine)
+	}
+	// Embed the hook DLL into a new section, followed by the new import
+	// directory (the import descriptors of the original executable, followed by
+	// those of the hook DLL).
+	buf := append([]byte(nil), file.Content...)
+	sectAddr := nextSectAddr(file)
+	if err := dll.relocate(file.OptHdr.ImageBase + uint64(sectAddr)); err != nil {
+		return errors.WithStack(err)
+	}
+	dllDescs, err := dll.importDescs(sectAddr)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	sect := append([]byte(nil), dll.image...)
+	impDirAddr := sectAddr + uint32(len(sect))
+	if impDir := file.DataDirs[dataDirImport]; impDir.Size > 0 {
+		origDescs := file.ReadData(file.OptHdr.ImageBase+uint64(impDir.RelAddr), int64(impDir.Size))
+		// Strip terminating null descriptor.
+		for i := 0; i+20 <= len(origDescs); i += 20 {
+			if isZero(origDescs[i : i+20]) {
+				origDescs = origDescs[:i]
+				break
+			}
+		}
+		sect = append(sect, origDescs...)
+	}
+	sect = append(sect, dllDescs...)
+	sect = append(sect, make([]byte, 20)...)
+	impDirSize := sectAddr + uint32(len(sect)) - impDirAddr
+	buf, err = addSection(buf, file, hookSectName, sect, sectAddr, hookSectFlags)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	hdr, err := parseHeaders(buf)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	hdr.setDataDir(buf, dataDirImport, impDirAddr, impDirSize)
+	// The certificate table is invalidated by patching.
+	if certDir := file.DataDirs[dataDirCert]; certDir.Size > 0 {
+		log.Printf("removing certificate table of %q; invalidated by patching", origPath)
+		hdr.setDataDir(buf, dataDirCert, 0, 0)
+	}
+	// The hook DLL and trampolines use absolute addresses of the preferred
+	// image base.
+	dllChars := binary.LittleEndian.Uint16(buf[hdr.opt+70:])
+	if dllChars&dynamicBase != 0 {
+		log.Printf("clearing DYNAMIC_BASE flag of %q; the patched executable is loaded at its preferred image base", origPath)
+		binary.LittleEndian.PutUint16(buf[hdr.opt+70:], dllChars&^dynamicBase)
+	}
+
+	// Inject jmp instructions to hooks.
+	var funcs []*hookedFunc
+	for _, inPath := range inPaths {
+		fns, err := parseHookAddrs(inPath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		funcs = append(funcs, fns...)
+	}
+	if err := resolveSyms(funcs, exe); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := checkHooks(funcs, exe, a); err != nil {
+		return errors.WithStack(err)
+	}
+	for _, fn := range funcs {
+		if fn.imp != nil {
+			// The import address table is overwritten by the loader, and
+			// initializers of the embedded hook DLL are not run.
+			if skip {
+				log.Printf("skipping function %q; import address table hooks of %q must be installed at runtime", fn.name, fn.imp)
+				continue
+			}
+			return errors.Errorf("unable to patch import address table hook of function %q (%q); must be installed at runtime", fn.name, fn.imp)
+		}
+		hookAddr, ok, err := dll.lookup(fn.name)
+		if err != nil {
+			return errors.Wrapf(err, "unable to locate hook of function %q in %q", fn.name, hooksPath)
+		}
+		if !ok {
+			if skip {
+				log.Printf("skipping function %q; hook not exported by %q", fn.name, hooksPath)
+				continue
+			}
+			return errors.Errorf("unable to locate hook of function %q in %q", fn.name, hooksPath)
+		}
+		target := dll.imageBase + uint64(hookAddr)
+		offset, err := fileOffset(file, fn.addr, a.JmpSize)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		copy(buf[offset:], a.jmp(fn.addr, target))
+	}
+	binary.LittleEndian.PutUint32(buf[hdr.opt+64:], checksum(buf, hdr.opt+64))
+	if err := ioutil.WriteFile(output, buf, 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// parseHookAddrs returns the functions to hook defined in the given LLVM IR
+// assembly file or declared in the given C header. Only the names and
+// addresses (or symbol names) of the functions are parsed.
+func parseHookAddrs(inPath string) ([]*hookedFunc, error) {
+	if isCHeader(inPath) {
+		protos, err := cparse.ParseFile(inPath)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		var funcs []*hookedFunc
+		for _, proto := range protos {
+			fn, err := targetFromProto(proto)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			funcs = append(funcs, fn)
+		}
+		return funcs, nil
+	}
+	m, err := mdutil.ParseFile(inPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var funcs []*hookedFunc
+	for _, f := range m.Funcs {
+		if len(f.Blocks) == 0 {
+			continue
+		}
+		locals, err := mdutil.LocalVars(f)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		fn, err := parseTarget(f, locals)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		funcs = append(funcs, fn)
+	}
+	return funcs, nil
+}
+
+// fileOffset returns the file offset of the n bytes at the given address.
+func fileOffset(file *pe.File, addr uint64, n int64) (int64, error) {
+	for _, sectHdr := range file.SectHdrs {
+		start := file.OptHdr.ImageBase + uint64(sectHdr.RelAddr)
+		end := start + uint64(sectHdr.DataSize)
+		if start <= addr && addr+uint64(n) <= end {
+			return int64(sectHdr.DataOffset) + int64(addr-start), nil
+		}
+	}
+	return 0, errors.Errorf("unable to locate file offset of address 0x%X (%d bytes)", addr, n)
+}
+
+// isZero reports whether the given bytes are all zero.
+func isZero(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/binary"
+
+	"github.com/mewmew/pe"
+	"github.com/pkg/errors"
+)
+
+// peHeaders records the file offsets of the headers of a PE file.
+type peHeaders struct {
+	// File offset of COFF file header.
+	coff int
+	// File offset of optional header.
+	opt int
+	// File offset of data directories.
+	dataDirs int
+	// Number of data directories.
+	ndataDirs int
+	// File offset of section table.
+	sects int
+}
+
+// parseHeaders locates the headers of the given PE file contents.
+func parseHeaders(buf []byte) (*peHeaders, error) {
+	if len(buf) < 0x40 || string(buf[:2]) != "MZ" {
+		return nil, errors.New("invalid DOS header")
+	}
+	peOffset := int(binary.LittleEndian.Uint32(buf[0x3C:]))
+	if peOffset+4+20 > len(buf) || string(buf[peOffset:peOffset+4]) != "PE\x00\x00" {
+		return nil, errors.New("invalid PE signature")
+	}
+	hdr := &peHeaders{coff: peOffset + 4}
+	hdr.opt = hdr.coff + 20
+	optSize := int(binary.LittleEndian.Uint16(buf[hdr.coff+16:]))
+	hdr.sects = hdr.opt + optSize
+	if hdr.sects > len(buf) {
+		return nil, errors.New("invalid optional header size")
+	}
+	switch magic := binary.LittleEndian.Uint16(buf[hdr.opt:]); magic {
+	case pe32PlusMagic:
+		hdr.ndataDirs = int(binary.LittleEndian.Uint32(buf[hdr.opt+108:]))
+		hdr.dataDirs = hdr.opt + 112
+	default:
+		hdr.ndataDirs = int(binary.LittleEndian.Uint32(buf[hdr.opt+92:]))
+		hdr.dataDirs = hdr.opt + 96
+	}
+	if hdr.dataDirs+8*hdr.ndataDirs > hdr.sects {
+		return nil, errors.Errorf("invalid number of data directories (%d)", hdr.ndataDirs)
+	}
+	return hdr, nil
+}
+
+// setDataDir sets the relative address and size of the given data directory.
+func (hdr *peHeaders) setDataDir(buf []byte, idx int, addr, size uint32) {
+	if idx >= hdr.ndataDirs {
+		return
+	}
+	binary.LittleEndian.PutUint32(buf[hdr.dataDirs+8*idx:], addr)
+	binary.LittleEndian.PutUint32(buf[hdr.dataDirs+8*idx+4:], size)
+}
+
+// nextSectAddr returns the relative address following the last section of the
+// given PE file, aligned to the section alignment.
+func nextSectAddr(file *pe.File) uint32 {
+	var end uint32
+	for _, sectHdr := range file.SectHdrs {
+		size := sectHdr.VirtualSize
+		if sectHdr.DataSize > size {
+			size = sectHdr.DataSize
+		}
+		if sectEnd := sectHdr.RelAddr + size; sectEnd > end {
+			end = sectEnd
+		}
+	}
+	return alignUp(end, file.OptHdr.SectionAlign)
+}
+
+// addSection appends a section with the given name, contents, relative address
+// and section flags to the contents of the PE file, updating the section
+// table, SizeOfImage and SizeOfCode. The updated file contents are returned.
+func addSection(buf []byte, file *pe.File, name string, data []byte, addr, flags uint32) ([]byte, error) {
+	hdr, err := parseHeaders(buf)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// Locate room for the section header.
+	nsects := int(binary.LittleEndian.Uint16(buf[hdr.coff+2:]))
+	sectHdrOffset := hdr.sects + 40*nsects
+	end := sectHdrOffset + 40
+	if end > int(file.OptHdr.HeadersSize) || !isZero(buf[sectHdrOffset:end]) {
+		return nil, errors.Errorf("no room for section header %q in PE headers", name)
+	}
+	for _, sectHdr := range file.SectHdrs {
+		if sectHdr.DataSize > 0 && end > int(sectHdr.DataOffset) {
+			return nil, errors.Errorf("no room for section header %q before section %q", name, sectHdr.Name)
+		}
+	}
+	// Append section contents, aligned to the file alignment.
+	fileAlign := file.OptHdr.FileAlign
+	dataOffset := alignUp(uint32(len(buf)), fileAlign)
+	dataSize := alignUp(uint32(len(data)), fileAlign)
+	buf = append(buf, make([]byte, int(dataOffset)-len(buf))...)
+	buf = append(buf, data...)
+	buf = append(buf, make([]byte, int(dataSize)-len(data))...)
+	// Write section header.
+	sectHdr := buf[sectHdrOffset:end]
+	copy(sectHdr[:8], name)
+	binary.LittleEndian.PutUint32(sectHdr[8:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(sectHdr[12:], addr)
+	binary.LittleEndian.PutUint32(sectHdr[16:], dataSize)
+	binary.LittleEndian.PutUint32(sectHdr[20:], dataOffset)
+	binary.LittleEndian.PutUint32(sectHdr[36:], flags)
+	binary.LittleEndian.PutUint16(buf[hdr.coff+2:], uint16(nsects+1))
+	// Update SizeOfImage and SizeOfCode.
+	imageSize := alignUp(addr+uint32(len(data)), file.OptHdr.SectionAlign)
+	binary.LittleEndian.PutUint32(buf[hdr.opt+56:], imageSize)
+	codeSize := binary.LittleEndian.Uint32(buf[hdr.opt+4:])
+	binary.LittleEndian.PutUint32(buf[hdr.opt+4:], codeSize+dataSize)
+	return buf, nil
+}
+
+// checksum returns the PE image checksum of the given file contents, skipping
+// the checksum field at the specified file offset.
+func checksum(buf []byte, checksumOffset int) uint32 {
+	var sum uint64
+	for i := 0; i < len(buf); i += 2 {
+		if i == checksumOffset || i == checksumOffset+2 {
+			continue
+		}
+		word := uint64(buf[i])
+		if i+1 < len(buf) {
+			word |= uint64(buf[i+1]) << 8
+		}
+		sum += word
+		sum = (sum & 0xFFFF) + (sum >> 16)
+	}
+	sum = (sum & 0xFFFF) + (sum >> 16)
+	return uint32(sum) + uint32(len(buf))
+}
+
+// alignUp returns x rounded up to the nearest multiple of align.
+func alignUp(x, align uint32) uint32 {
+	if align == 0 {
+		return x
+	}
+	return (x + align - 1) / align * align
+}
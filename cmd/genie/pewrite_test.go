@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/mewmew/pe"
+)
+
+// newTestHeaders returns the contents of a minimal PE32 file, consisting of the
+// PE headers only.
+func newTestHeaders() []byte {
+	buf := make([]byte, 0x200)
+	copy(buf, "MZ")
+	binary.LittleEndian.PutUint32(buf[0x3C:], 0x40)
+	copy(buf[0x40:], "PE\x00\x00")
+	// SizeOfOptionalHeader.
+	binary.LittleEndian.PutUint16(buf[0x44+16:], 0xE0)
+	// Magic and NumberOfRvaAndSizes.
+	binary.LittleEndian.PutUint16(buf[0x58:], 0x10B)
+	binary.LittleEndian.PutUint32(buf[0x58+92:], 16)
+	return buf
+}
+
+func TestParseHeaders(t *testing.T) {
+	buf := newTestHeaders()
+	hdr, err := parseHeaders(buf)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	want := peHeaders{coff: 0x44, opt: 0x58, dataDirs: 0xB8, ndataDirs: 16, sects: 0x138}
+	if *hdr != want {
+		t.Errorf("PE headers mismatch; expected %+v, got %+v", want, *hdr)
+	}
+	hdr.setDataDir(buf, dataDirCert, 0x1234, 0x10)
+	if addr, size := binary.LittleEndian.Uint32(buf[0xB8+8*4:]), binary.LittleEndian.Uint32(buf[0xB8+8*4+4:]); addr != 0x1234 || size != 0x10 {
+		t.Errorf("data directory mismatch; expected (0x1234, 0x10), got (0x%X, 0x%X)", addr, size)
+	}
+	// Invalid headers.
+	golden := []struct {
+		name string
+		buf  []byte
+	}{
+		{name: "truncated", buf: buf[:0x20]},
+		{name: "DOS signature", buf: append([]byte("ZM"), buf[2:]...)},
+		{name: "PE signature", buf: append(append(append([]byte{}, buf[:0x40]...), "NE\x00\x00"...), buf[0x44:]...)},
+	}
+	for _, g := range golden {
+		if _, err := parseHeaders(g.buf); err == nil {
+			t.Errorf("%s: expected error, got nil", g.name)
+		}
+	}
+}
+
+func TestAddSection(t *testing.T) {
+	buf := newTestHeaders()
+	file := &pe.File{
+		OptHdr: &pe.OptHeader{SectionAlign: 0x1000, FileAlign: 0x200, HeadersSize: 0x200},
+	}
+	const flags = 0x60000020
+	buf, err := addSection(buf, file, ".genie", []byte("abc"), 0x1000, flags)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(buf) != 0x400 {
+		t.Errorf("file size mismatch; expected 0x400, got 0x%X", len(buf))
+	}
+	if nsects := binary.LittleEndian.Uint16(buf[0x44+2:]); nsects != 1 {
+		t.Errorf("section count mismatch; expected 1, got %d", nsects)
+	}
+	sectHdr := buf[0x138 : 0x138+40]
+	golden := []struct {
+		name   string
+		offset int
+		want   uint32
+	}{
+		{name: "VirtualSize", offset: 8, want: 3},
+		{name: "VirtualAddress", offset: 12, want: 0x1000},
+		{name: "SizeOfRawData", offset: 16, want: 0x200},
+		{name: "PointerToRawData", offset: 20, want: 0x200},
+		{name: "Characteristics", offset: 36, want: flags},
+	}
+	for _, g := range golden {
+		if got := binary.LittleEndian.Uint32(sectHdr[g.offset:]); got != g.want {
+			t.Errorf("%s mismatch; expected 0x%X, got 0x%X", g.name, g.want, got)
+		}
+	}
+	if string(sectHdr[:6]) != ".genie" || string(buf[0x200:0x203]) != "abc" {
+		t.Errorf("section contents mismatch")
+	}
+	if size := binary.LittleEndian.Uint32(buf[0x58+56:]); size != 0x2000 {
+		t.Errorf("SizeOfImage mismatch; expected 0x2000, got 0x%X", size)
+	}
+	if size := binary.LittleEndian.Uint32(buf[0x58+4:]); size != 0x200 {
+		t.Errorf("SizeOfCode mismatch; expected 0x200, got 0x%X", size)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	golden := []struct {
+		buf            []byte
+		checksumOffset int
+		want           uint32
+	}{
+		// Checksum field skipped.
+		{buf: []byte{1, 0, 2, 0, 0xFF, 0xFF, 0xFF, 0xFF, 3, 0}, checksumOffset: 4, want: 6 + 10},
+		// Carry folded into the low 16 bits.
+		{buf: []byte{0xFF, 0xFF, 2, 0}, checksumOffset: 100, want: 2 + 4},
+		// Odd size.
+		{buf: []byte{1, 0, 2}, checksumOffset: 100, want: 3 + 3},
+	}
+	for _, g := range golden {
+		if got := checksum(g.buf, g.checksumOffset); got != g.want {
+			t.Errorf("% X: checksum mismatch; expected %d, got %d", g.buf, g.want, got)
+		}
+	}
+}
+
+func TestAlignUp(t *testing.T) {
+	golden := []struct {
+		x, align, want uint32
+	}{
+		{x: 0, align: 0x200, want: 0},
+		{x: 1, align: 0x200, want: 0x200},
+		{x: 0x200, align: 0x200, want: 0x200},
+		{x: 0x1001, align: 0x1000, want: 0x2000},
+		{x: 7, align: 0, want: 7},
+	}
+	for _, g := range golden {
+		if got := alignUp(g.x, g.align); got != g.want {
+			t.Errorf("alignUp(0x%X, 0x%X) mismatch; expected 0x%X, got 0x%X", g.x, g.align, g.want, got)
+		}
+	}
+}
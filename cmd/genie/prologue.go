@@ -0,0 +1,129 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// maxFuncSize is the maximum number of bytes decoded when locating branch
+// targets of a function.
+const maxFuncSize = 64 * 1024
+
+// patchSize returns the size in number of bytes of the patch injected at the
+// start of the function at the given address; i.e. the size of the injected jmp
+// instruction rounded up to whole instructions of the original prologue.
+func (a *arch) patchSize(exe executable, addr uint64) (int64, error) {
+	code, err := readCode(exe, addr)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return a.prologueSize(code, addr)
+}
+
+// prologueSize returns the size in number of bytes of the patch injected at the
+// start of the given code of the function at address addr.
+//
+// The function is decoded by linear sweep until its end (a ret or jmp
+// instruction not followed by any targets of conditional branches within the
+// function), and an error is reported if the function is shorter than the jmp
+// instruction, or if the patch would overwrite the target of a branch within
+// the function. Targets of unconditional jmp instructions do not extend the
+// function, as they may be tail jumps to other functions.
+func (a *arch) prologueSize(code []byte, addr uint64) (int64, error) {
+	if len(code) > maxFuncSize {
+		code = code[:maxFuncSize]
+	}
+	var (
+		// Size of patch; set once the jmp instruction is covered.
+		size int64
+		// Offset of the end of the function, as known so far.
+		end int64
+		// Offsets of branch targets within the function.
+		targets []int64
+	)
+	for pc := int64(0); pc < int64(len(code)); {
+		inst, err := x86asm.Decode(code[pc:], a.mode())
+		if err != nil {
+			if size == 0 {
+				return 0, errors.Errorf("unable to decode instruction at address 0x%X; %v", addr+uint64(pc), err)
+			}
+			// Data or padding after the function.
+			break
+		}
+		next := pc + int64(inst.Len)
+		if rel, ok := inst.Args[0].(x86asm.Rel); ok && inst.Op != x86asm.CALL {
+			target := next + int64(rel)
+			targets = append(targets, target)
+			if isCondBranch(inst.Op) && target > end {
+				end = target
+			}
+		}
+		if next > end {
+			end = next
+		}
+		if size == 0 && next >= a.JmpSize {
+			size = next
+		}
+		if isFuncEnd(inst) && next >= end {
+			break
+		}
+		pc = next
+	}
+	if size == 0 || end < size {
+		return 0, errors.Errorf("function at address 0x%X ends within the %d-byte jmp instruction", addr, a.JmpSize)
+	}
+	for _, target := range targets {
+		if 0 < target && target < size {
+			return 0, errors.Errorf("patch of function at address 0x%X (%d bytes) overwrites branch target at address 0x%X", addr, size, addr+uint64(target))
+		}
+	}
+	return size, nil
+}
+
+// isFuncEnd reports whether the given instruction does not continue execution
+// at the next instruction; thus possibly ending a function.
+func isFuncEnd(inst x86asm.Inst) bool {
+	switch inst.Op {
+	case x86asm.RET, x86asm.LRET, x86asm.IRET, x86asm.IRETD, x86asm.IRETQ, x86asm.JMP, x86asm.LJMP, x86asm.UD2, x86asm.HLT:
+		return true
+	case x86asm.INT:
+		// int3 is used as padding between functions.
+		return inst.Args[0] == x86asm.Imm(3)
+	}
+	return false
+}
+
+// isCondBranch reports whether the given opcode is a conditional branch.
+func isCondBranch(op x86asm.Op) bool {
+	switch op {
+	case x86asm.JA, x86asm.JAE, x86asm.JB, x86asm.JBE, x86asm.JE, x86asm.JG, x86asm.JGE, x86asm.JL, x86asm.JLE, x86asm.JNE, x86asm.JNO, x86asm.JNP, x86asm.JNS, x86asm.JO, x86asm.JP, x86asm.JS:
+		return true
+	case x86asm.JCXZ, x86asm.JECXZ, x86asm.JRCXZ, x86asm.LOOP, x86asm.LOOPE, x86asm.LOOPNE:
+		return true
+	}
+	return false
+}
+
+// readCode returns the contents of the section containing the given address,
+// starting at the address.
+func readCode(exe executable, addr uint64) ([]byte, error) {
+	sect := sectionOf(exe, addr)
+	if sect == nil {
+		return nil, errors.Errorf("unable to locate section containing address 0x%X", addr)
+	}
+	return sect.Data[addr-sect.Addr:], nil
+}
+
+// checkRelocs reports an error if the loader relocates an absolute address
+// within the n-byte patch at the given address. The relocated address would not
+// be valid when executing the copied prologue from the trampoline at a base
+// address other than the preferred one.
+func (a *arch) checkRelocs(exe executable, addr uint64, n int64) error {
+	ptrSize := uint64(a.mode() / 8)
+	for _, reloc := range exe.Relocs() {
+		if reloc < addr+uint64(n) && addr < reloc+ptrSize {
+			return errors.Errorf("patch of function at address 0x%X (%d bytes) contains absolute address at 0x%X relocated by the loader", addr, n, reloc)
+		}
+	}
+	return nil
+}
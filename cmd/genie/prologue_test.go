@@ -0,0 +1,127 @@
+package main
+
+import "testing"
+
+func TestPrologueSize(t *testing.T) {
+	const addr = 0x401000
+	x86 := &arch{Machine: machine386, JmpSize: 5}
+	x64 := &arch{Machine: machineAMD64, JmpSize: 14}
+	// Prologue of the function following the function to hook.
+	next := []byte{0x55, 0x48, 0x89, 0xE5, 0x48, 0x83, 0xEC, 0x20, 0x89, 0x7D, 0xFC, 0x8B, 0x45, 0xFC, 0xC9, 0xC3}
+	golden := []struct {
+		name string
+		a    *arch
+		code []byte
+		// Expected patch size; or zero if an error is expected.
+		want int64
+	}{
+		{
+			// push rbp; mov rbp, rsp; sub rsp, 0x20; mov [rbp-4], edi;
+			// mov eax, [rbp-4]; leave; ret
+			name: "x64 frame",
+			a:    x64,
+			code: next,
+			want: 14,
+		},
+		{
+			// jmp rel32 (tail jump to another function)
+			name: "x64 jmp thunk",
+			a:    x64,
+			code: append([]byte{0xE9, 0x00, 0x10, 0x00, 0x00}, next...),
+		},
+		{
+			// jmp rel8 to a far label
+			name: "x86 short jmp thunk",
+			a:    x86,
+			code: append([]byte{0xEB, 0x7F, 0xCC, 0xCC}, next...),
+		},
+		{
+			// jmp rel32; patch replaces the thunk exactly.
+			name: "x86 jmp thunk",
+			a:    x86,
+			code: append([]byte{0xE9, 0x00, 0x10, 0x00, 0x00}, next...),
+			want: 5,
+		},
+		{
+			// test eax, eax; je 1f; ret; 1: xor eax, eax; ret
+			name: "x86 jcc over ret",
+			a:    x86,
+			code: []byte{0x85, 0xC0, 0x74, 0x01, 0xC3, 0x31, 0xC0, 0xC3},
+			want: 5,
+		},
+		{
+			// test eax, eax; je 1f; ret; 1: xor eax, eax; ret
+			name: "x64 jcc over ret",
+			a:    x64,
+			code: append([]byte{0x85, 0xC0, 0x74, 0x01, 0xC3, 0x31, 0xC0, 0xC3}, next...),
+		},
+		{
+			// push ebp; mov ebp, esp; sub esp, 0x10;
+			// 1: inc eax; cmp eax, 0x10; jl 1b; leave; ret
+			name: "x86 backward loop after patch",
+			a:    x86,
+			code: []byte{0x55, 0x89, 0xE5, 0x83, 0xEC, 0x10, 0x40, 0x3D, 0x10, 0x00, 0x00, 0x00, 0x7C, 0xF8, 0xC9, 0xC3},
+			want: 6,
+		},
+		{
+			// xor eax, eax; 1: inc eax; cmp eax, 0x10; jl 1b; ret
+			name: "x86 backward loop into patch",
+			a:    x86,
+			code: []byte{0x31, 0xC0, 0x40, 0x3D, 0x10, 0x00, 0x00, 0x00, 0x7C, 0xF8, 0xC3},
+		},
+		{
+			// ret
+			name: "x86 function shorter than jmp",
+			a:    x86,
+			code: append([]byte{0xC3}, next...),
+		},
+	}
+	for _, g := range golden {
+		got, err := g.a.prologueSize(g.code, addr)
+		if g.want == 0 {
+			if err == nil {
+				t.Errorf("%s: expected error, got patch size %d", g.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unable to determine patch size; %v", g.name, err)
+			continue
+		}
+		if got != g.want {
+			t.Errorf("%s: patch size mismatch; expected %d, got %d", g.name, g.want, got)
+		}
+	}
+}
+
+func TestCheckRelocs(t *testing.T) {
+	const addr = 0x401000
+	x86 := &arch{Machine: machine386, JmpSize: 5}
+	x64 := &arch{Machine: machineAMD64, JmpSize: 14}
+	golden := []struct {
+		name   string
+		a      *arch
+		relocs []uint64
+		n      int64
+		// Expect relocation within patch.
+		err bool
+	}{
+		{name: "x86 none", a: x86, n: 6},
+		{name: "x86 after patch", a: x86, relocs: []uint64{0x401006}, n: 6},
+		{name: "x86 before patch", a: x86, relocs: []uint64{0x400FFC}, n: 6},
+		{name: "x86 within patch", a: x86, relocs: []uint64{0x401002}, n: 6, err: true},
+		{name: "x86 overlapping start of patch", a: x86, relocs: []uint64{0x400FFD}, n: 6, err: true},
+		{name: "x64 overlapping start of patch", a: x64, relocs: []uint64{0x400FF9}, n: 14, err: true},
+		{name: "x64 before patch", a: x64, relocs: []uint64{0x400FF8, 0x40100E}, n: 14},
+	}
+	for _, g := range golden {
+		exe := &elfExecutable{machine: g.a.Machine, relocs: g.relocs}
+		err := g.a.checkRelocs(exe, addr, g.n)
+		switch {
+		case g.err && err == nil:
+			t.Errorf("%s: expected error, got nil", g.name)
+		case !g.err && err != nil:
+			t.Errorf("%s: %+v", g.name, err)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// relocateAsm returns the AT&T syntax assembly of the given PC-relative
+// instruction at address pc, rewritten to be position independent, so that it
+// may be executed from the trampoline. The end address specifies the end of the
+// relocated prologue.
+//
+// Relative jmp instructions are rewritten to absolute jumps. Conditional
+// branches (short or near) are expanded into a short conditional branch over an
+// absolute jmp to the original target. Relative call instructions push the
+// original return address and jump to the callee, so that the callee observes
+// the same return address as in the original function (e.g.
+// __x86.get_pc_thunk.bx and "call $+5; pop"); as the callee returns to the
+// original function, calls are only relocated at the end of the prologue.
+//
+// On x86-64, RIP-relative lea instructions and mov loads into 32- or 64-bit
+// general purpose registers are rewritten to load the absolute address into the
+// destination register. Indirect jmp and call instructions through RIP-relative
+// memory operands load the target from the absolute address. Any other
+// instruction with a RIP-relative memory operand (e.g. stores, cmp, test and
+// arithmetic) is rewritten to access memory through a scratch register, which
+// is saved and restored around the instruction.
+//
+// If tab is non-nil, absolute addresses are loaded from the table of runtime
+// addresses instead (see addrTable).
+func (a *arch) relocateAsm(inst x86asm.Inst, pc, end uint64, tab *addrTable) ([]string, error) {
+	next := pc + uint64(inst.Len)
+	if rel, ok := inst.Args[0].(x86asm.Rel); ok {
+		target := next + uint64(int64(rel))
+		switch {
+		case inst.Op == x86asm.JMP:
+			return a.jmpAsm(target, tab), nil
+		case inst.Op == x86asm.CALL && target == next:
+			// "call $+5" pushes the address of the next instruction.
+			return a.pushAddrAsm(next, tab), nil
+		case inst.Op == x86asm.CALL:
+			if next != end {
+				return nil, errors.Errorf("unable to relocate call instruction at address 0x%X within prologue; the callee would return to the overwritten prologue", pc)
+			}
+			return append(a.pushAddrAsm(next, tab), a.jmpAsm(target, tab)...), nil
+		case isCondBranch(inst.Op):
+			asm := []string{strings.ToLower(inst.Op.String()) + " 1f", "jmp 2f", "1:"}
+			asm = append(asm, a.jmpAsm(target, tab)...)
+			return append(asm, "2:"), nil
+		}
+	}
+	for i, arg := range inst.Args {
+		mem, ok := arg.(x86asm.Mem)
+		if !ok || mem.Base != x86asm.RIP || mem.Index != 0 {
+			continue
+		}
+		target := next + uint64(mem.Disp)
+		dst, isReg := inst.Args[0].(x86asm.Reg)
+		switch {
+		case mem.Segment != 0:
+			// Segment relative memory operands (e.g. thread-local storage)
+			// cannot be relocated; the instruction is rejected below.
+		case inst.Op == x86asm.JMP:
+			return a.jmpMemAsm(target, tab), nil
+		case inst.Op == x86asm.CALL:
+			if next != end {
+				return nil, errors.Errorf("unable to relocate call instruction at address 0x%X within prologue; the callee would return to the overwritten prologue", pc)
+			}
+			return append(a.pushAddrAsm(next, tab), a.jmpMemAsm(target, tab)...), nil
+		case inst.Op == x86asm.LEA && isReg && x86asm.RAX <= dst && dst <= x86asm.R15:
+			return a.loadAddrAsm(dst, target, tab), nil
+		case inst.Op == x86asm.LEA && isReg && x86asm.EAX <= dst && dst <= x86asm.R15L:
+			if tab != nil {
+				return []string{fmt.Sprintf("movl %s(%%rip), %s", tab.ref(target), regName(dst))}, nil
+			}
+			return []string{fmt.Sprintf("movl $0x%X, %s", uint32(target), regName(dst))}, nil
+		case inst.Op == x86asm.MOV && isReg && x86asm.RAX <= dst && dst <= x86asm.R15:
+			asm := a.loadAddrAsm(dst, target, tab)
+			return append(asm, fmt.Sprintf("movq (%s), %s", regName(dst), regName(dst))), nil
+		case inst.Op == x86asm.MOV && isReg && x86asm.EAX <= dst && dst <= x86asm.R15L:
+			// Use the 64-bit register overlapping the destination register to
+			// hold the address.
+			reg := gpr64(dst)
+			asm := a.loadAddrAsm(reg, target, tab)
+			return append(asm, fmt.Sprintf("movl (%s), %s", regName(reg), regName(dst))), nil
+		default:
+			return a.scratchAsm(inst, i, pc, target, tab)
+		}
+		break
+	}
+	return nil, errors.Errorf("unable to relocate PC-relative instruction %q at address 0x%X", x86asm.IntelSyntax(inst, pc, nil), pc)
+}
+
+// scratchRegs specifies the registers used, in order of preference, as scratch
+// registers to hold the absolute address of RIP-relative memory operands. None
+// are used implicitly by instructions with memory operands.
+var scratchRegs = []x86asm.Reg{x86asm.R11, x86asm.R10, x86asm.R9, x86asm.R8}
+
+// legacyScratchRegs specifies the scratch registers of instructions accessing
+// the high byte registers (AH, CH, DH and BH), which cannot be encoded with a
+// REX prefix.
+var legacyScratchRegs = []x86asm.Reg{x86asm.RSI, x86asm.RDI, x86asm.RBX}
+
+// scratchAsm returns the AT&T syntax assembly of the given instruction at
+// address pc, rewritten to access its RIP-relative memory operand (the i:th
+// argument) at the given address through a scratch register.
+//
+// On Unix, the red zone below the stack pointer is skipped before saving the
+// scratch register, as it may hold data of the original function.
+func (a *arch) scratchAsm(inst x86asm.Inst, i int, pc, target uint64, tab *addrTable) ([]string, error) {
+	switch inst.Op {
+	case x86asm.PUSH, x86asm.POP:
+		return nil, errors.Errorf("unable to relocate stack instruction %q at address 0x%X", x86asm.IntelSyntax(inst, pc, nil), pc)
+	}
+	regs := scratchRegs
+	used := make(map[x86asm.Reg]bool)
+	for _, arg := range inst.Args {
+		if reg, ok := arg.(x86asm.Reg); ok {
+			used[gpr64(reg)] = true
+			if x86asm.AH <= reg && reg <= x86asm.BH {
+				regs = legacyScratchRegs
+			}
+		}
+	}
+	if used[x86asm.RSP] {
+		return nil, errors.Errorf("unable to relocate stack instruction %q at address 0x%X", x86asm.IntelSyntax(inst, pc, nil), pc)
+	}
+	var scratch x86asm.Reg
+	for _, reg := range regs {
+		if !used[reg] {
+			scratch = reg
+			break
+		}
+	}
+	mem := inst.Args[i].(x86asm.Mem)
+	inst.Args[i] = x86asm.Mem{Base: scratch, Disp: 0, Segment: mem.Segment}
+	var asm []string
+	if !a.Windows {
+		asm = append(asm, "leaq -128(%rsp), %rsp")
+	}
+	asm = append(asm, "pushq "+regName(scratch))
+	asm = append(asm, a.loadAddrAsm(scratch, target, tab)...)
+	asm = append(asm, x86asm.GNUSyntax(inst, pc, nil))
+	asm = append(asm, "popq "+regName(scratch))
+	if !a.Windows {
+		asm = append(asm, "leaq 128(%rsp), %rsp")
+	}
+	return asm, nil
+}
+
+// loadAddrAsm returns the AT&T syntax assembly loading the given absolute
+// address into the 64-bit register. If tab is non-nil, the runtime address is
+// loaded from the table of runtime addresses.
+func (a *arch) loadAddrAsm(reg x86asm.Reg, addr uint64, tab *addrTable) []string {
+	if tab != nil {
+		return []string{fmt.Sprintf("movq %s(%%rip), %s", tab.ref(addr), regName(reg))}
+	}
+	return []string{fmt.Sprintf("movabsq $0x%X, %s", addr, regName(reg))}
+}
+
+// jmpAsm returns the AT&T syntax assembly of an absolute jmp to the given
+// address, which does not clobber any registers. If tab is non-nil, the
+// runtime address is loaded from the table of runtime addresses.
+func (a *arch) jmpAsm(target uint64, tab *addrTable) []string {
+	if tab != nil {
+		if a.is64() {
+			return []string{fmt.Sprintf("jmp *%s(%%rip)", tab.ref(target))}
+		}
+		return []string{fmt.Sprintf("jmp *%s", tab.ref(target))}
+	}
+	if a.is64() {
+		return []string{"jmp *0(%rip)", fmt.Sprintf(".quad 0x%X", target)}
+	}
+	return []string{fmt.Sprintf("pushl $0x%X", target), "ret"}
+}
+
+// jmpMemAsm returns the AT&T syntax assembly of an x86-64 indirect jmp through
+// the memory at the given absolute address, which does not clobber any
+// registers. If tab is non-nil, the runtime address is loaded from the table of
+// runtime addresses.
+func (a *arch) jmpMemAsm(target uint64, tab *addrTable) []string {
+	asm := []string{"pushq %rax"}
+	asm = append(asm, a.loadAddrAsm(x86asm.RAX, target, tab)...)
+	return append(asm, "movq (%rax), %rax", "xchgq %rax, (%rsp)", "ret")
+}
+
+// pushAddrAsm returns the AT&T syntax assembly pushing the given absolute
+// address onto the stack, which does not clobber any registers. If tab is
+// non-nil, the runtime address is loaded from the table of runtime addresses.
+func (a *arch) pushAddrAsm(addr uint64, tab *addrTable) []string {
+	if tab != nil {
+		if a.is64() {
+			return []string{fmt.Sprintf("pushq %s(%%rip)", tab.ref(addr))}
+		}
+		return []string{fmt.Sprintf("pushl %s", tab.ref(addr))}
+	}
+	if a.is64() {
+		return []string{"pushq 1f(%rip)", "jmp 2f", "1:", fmt.Sprintf(".quad 0x%X", addr), "2:"}
+	}
+	return []string{fmt.Sprintf("pushl $0x%X", addr)}
+}
+
+// gpr64 returns the 64-bit general purpose register overlapping the given
+// register; or the register itself if not a general purpose register.
+func gpr64(reg x86asm.Reg) x86asm.Reg {
+	switch {
+	case x86asm.AL <= reg && reg <= x86asm.BL:
+		return x86asm.RAX + (reg - x86asm.AL)
+	case x86asm.AH <= reg && reg <= x86asm.BH:
+		return x86asm.RAX + (reg - x86asm.AH)
+	case x86asm.SPB <= reg && reg <= x86asm.R15B:
+		return x86asm.RSP + (reg - x86asm.SPB)
+	case x86asm.AX <= reg && reg <= x86asm.R15W:
+		return x86asm.RAX + (reg - x86asm.AX)
+	case x86asm.EAX <= reg && reg <= x86asm.R15L:
+		return x86asm.RAX + (reg - x86asm.EAX)
+	}
+	return reg
+}
+
+// regName returns the AT&T syntax name of the given register.
+func regName(reg x86asm.Reg) string {
+	name := strings.ToLower(reg.String())
+	if x86asm.R8L <= reg && reg <= x86asm.R15L {
+		// The low 32 bits of R8 are named R8L by x86asm, and r8d by AT&T syntax.
+		name = strings.TrimSuffix(name, "l") + "d"
+	}
+	return "%" + name
+}
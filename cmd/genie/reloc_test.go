@@ -0,0 +1,236 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/arch/x86/x86asm"
+)
+
+func TestRelocateAsm(t *testing.T) {
+	const pc = 0x401000
+	x86 := &arch{Machine: machine386, JmpSize: 5}
+	x64 := &arch{Machine: machineAMD64, JmpSize: 14}
+	x64Windows := &arch{Machine: machineAMD64, Windows: true, JmpSize: 14}
+	golden := []struct {
+		a    *arch
+		code []byte
+		// Trailing bytes of prologue after instruction.
+		trailing uint64
+		// Relocate using a table of runtime addresses.
+		tab  bool
+		want []string
+	}{
+		// jmp 0x401015
+		{
+			a:    x86,
+			code: []byte{0xE9, 0x10, 0x00, 0x00, 0x00},
+			want: []string{"pushl $0x401015", "ret"},
+		},
+		// jne 0x401012
+		{
+			a:        x86,
+			code:     []byte{0x75, 0x10},
+			trailing: 3,
+			want:     []string{"jne 1f", "jmp 2f", "1:", "pushl $0x401012", "ret", "2:"},
+		},
+		// call 0x401015
+		{
+			a:    x86,
+			code: []byte{0xE8, 0x10, 0x00, 0x00, 0x00},
+			want: []string{"pushl $0x401005", "pushl $0x401015", "ret"},
+		},
+		{
+			a:    x64,
+			code: []byte{0xE8, 0x10, 0x00, 0x00, 0x00},
+			want: []string{"pushq 1f(%rip)", "jmp 2f", "1:", ".quad 0x401005", "2:", "jmp *0(%rip)", ".quad 0x401015"},
+		},
+		// call $+5
+		{
+			a:        x86,
+			code:     []byte{0xE8, 0x00, 0x00, 0x00, 0x00},
+			trailing: 1,
+			want:     []string{"pushl $0x401005"},
+		},
+		{
+			a:        x64,
+			code:     []byte{0xE8, 0x00, 0x00, 0x00, 0x00},
+			trailing: 9,
+			want:     []string{"pushq 1f(%rip)", "jmp 2f", "1:", ".quad 0x401005", "2:"},
+		},
+		// lea rax, [rip+0x10]
+		{
+			a:    x64,
+			code: []byte{0x48, 0x8D, 0x05, 0x10, 0x00, 0x00, 0x00},
+			want: []string{"movabsq $0x401017, %rax"},
+		},
+		// lea ecx, [rip+0x10]
+		{
+			a:    x64,
+			code: []byte{0x8D, 0x0D, 0x10, 0x00, 0x00, 0x00},
+			want: []string{"movl $0x401016, %ecx"},
+		},
+		// mov r8, qword ptr [rip+0x10]
+		{
+			a:    x64,
+			code: []byte{0x4C, 0x8B, 0x05, 0x10, 0x00, 0x00, 0x00},
+			want: []string{"movabsq $0x401017, %r8", "movq (%r8), %r8"},
+		},
+		// mov eax, dword ptr [rip+0x10]
+		{
+			a:    x64,
+			code: []byte{0x8B, 0x05, 0x10, 0x00, 0x00, 0x00},
+			want: []string{"movabsq $0x401016, %rax", "movl (%rax), %eax"},
+		},
+		// mov dword ptr [rip+0x10], eax
+		{
+			a:    x64,
+			code: []byte{0x89, 0x05, 0x10, 0x00, 0x00, 0x00},
+			want: []string{"leaq -128(%rsp), %rsp", "pushq %r11", "movabsq $0x401016, %r11", "mov %eax,(%r11)", "popq %r11", "leaq 128(%rsp), %rsp"},
+		},
+		{
+			a:    x64Windows,
+			code: []byte{0x89, 0x05, 0x10, 0x00, 0x00, 0x00},
+			want: []string{"pushq %r11", "movabsq $0x401016, %r11", "mov %eax,(%r11)", "popq %r11"},
+		},
+		// mov byte ptr [rip+0x10], ah
+		{
+			a:    x64Windows,
+			code: []byte{0x88, 0x25, 0x10, 0x00, 0x00, 0x00},
+			want: []string{"pushq %rsi", "movabsq $0x401016, %rsi", "mov %ah,(%rsi)", "popq %rsi"},
+		},
+		// cmp dword ptr [rip+0x10], 0x5
+		{
+			a:    x64Windows,
+			code: []byte{0x83, 0x3D, 0x10, 0x00, 0x00, 0x00, 0x05},
+			want: []string{"pushq %r11", "movabsq $0x401017, %r11", "cmpl $0x5,(%r11)", "popq %r11"},
+		},
+		// test byte ptr [rip+0x10], 0x1
+		{
+			a:    x64Windows,
+			code: []byte{0xF6, 0x05, 0x10, 0x00, 0x00, 0x00, 0x01},
+			want: []string{"pushq %r11", "movabsq $0x401017, %r11", "testb $0x1,(%r11)", "popq %r11"},
+		},
+		// add r11, qword ptr [rip+0x10]
+		{
+			a:    x64Windows,
+			code: []byte{0x4C, 0x03, 0x1D, 0x10, 0x00, 0x00, 0x00},
+			want: []string{"pushq %r10", "movabsq $0x401017, %r10", "add (%r10),%r11", "popq %r10"},
+		},
+		// jmp qword ptr [rip+0x10]
+		{
+			a:    x64,
+			code: []byte{0xFF, 0x25, 0x10, 0x00, 0x00, 0x00},
+			want: []string{"pushq %rax", "movabsq $0x401016, %rax", "movq (%rax), %rax", "xchgq %rax, (%rsp)", "ret"},
+		},
+		// call qword ptr [rip+0x10]
+		{
+			a:    x64,
+			code: []byte{0xFF, 0x15, 0x10, 0x00, 0x00, 0x00},
+			want: []string{"pushq 1f(%rip)", "jmp 2f", "1:", ".quad 0x401006", "2:", "pushq %rax", "movabsq $0x401016, %rax", "movq (%rax), %rax", "xchgq %rax, (%rsp)", "ret"},
+		},
+		// Relocation using a table of runtime addresses.
+		{
+			a:        x86,
+			code:     []byte{0x75, 0x10},
+			trailing: 3,
+			tab:      true,
+			want:     []string{"jne 1f", "jmp 2f", "1:", "jmp *f_addrs_genie+0", "2:"},
+		},
+		{
+			a:    x86,
+			code: []byte{0xE8, 0x10, 0x00, 0x00, 0x00},
+			tab:  true,
+			want: []string{"pushl f_addrs_genie+0", "jmp *f_addrs_genie+4"},
+		},
+		{
+			a:    x64,
+			code: []byte{0xE8, 0x10, 0x00, 0x00, 0x00},
+			tab:  true,
+			want: []string{"pushq f_addrs_genie+0(%rip)", "jmp *f_addrs_genie+8(%rip)"},
+		},
+		{
+			a:        x64,
+			code:     []byte{0xE8, 0x00, 0x00, 0x00, 0x00},
+			trailing: 9,
+			tab:      true,
+			want:     []string{"pushq f_addrs_genie+0(%rip)"},
+		},
+		{
+			a:    x64,
+			code: []byte{0x8D, 0x0D, 0x10, 0x00, 0x00, 0x00},
+			tab:  true,
+			want: []string{"movl f_addrs_genie+0(%rip), %ecx"},
+		},
+		{
+			a:    x64,
+			code: []byte{0x4C, 0x8B, 0x05, 0x10, 0x00, 0x00, 0x00},
+			tab:  true,
+			want: []string{"movq f_addrs_genie+0(%rip), %r8", "movq (%r8), %r8"},
+		},
+		{
+			a:    x64Windows,
+			code: []byte{0x89, 0x05, 0x10, 0x00, 0x00, 0x00},
+			tab:  true,
+			want: []string{"pushq %r11", "movq f_addrs_genie+0(%rip), %r11", "mov %eax,(%r11)", "popq %r11"},
+		},
+		{
+			a:    x64,
+			code: []byte{0xFF, 0x15, 0x10, 0x00, 0x00, 0x00},
+			tab:  true,
+			want: []string{"pushq f_addrs_genie+0(%rip)", "pushq %rax", "movq f_addrs_genie+8(%rip), %rax", "movq (%rax), %rax", "xchgq %rax, (%rsp)", "ret"},
+		},
+	}
+	for _, g := range golden {
+		inst, err := x86asm.Decode(g.code, g.a.mode())
+		if err != nil {
+			t.Errorf("% X: unable to decode instruction; %v", g.code, err)
+			continue
+		}
+		var tab *addrTable
+		if g.tab {
+			tab = &addrTable{Sym: "f_addrs_genie", imageBase: 0x400000, entrySize: g.a.mode() / 8}
+		}
+		end := pc + uint64(inst.Len) + g.trailing
+		got, err := g.a.relocateAsm(inst, pc, end, tab)
+		if err != nil {
+			t.Errorf("%q: unable to relocate instruction; %v", x86asm.IntelSyntax(inst, pc, nil), err)
+			continue
+		}
+		if !reflect.DeepEqual(got, g.want) {
+			t.Errorf("%q: assembly mismatch; expected %q, got %q", x86asm.IntelSyntax(inst, pc, nil), g.want, got)
+		}
+	}
+}
+
+func TestRelocateAsmInvalid(t *testing.T) {
+	const pc = 0x401000
+	x64 := &arch{Machine: machineAMD64, JmpSize: 14}
+	golden := []struct {
+		code []byte
+		// Trailing bytes of prologue after instruction.
+		trailing uint64
+	}{
+		// call 0x401015; within prologue.
+		{code: []byte{0xE8, 0x10, 0x00, 0x00, 0x00}, trailing: 1},
+		// call qword ptr [rip+0x10]; within prologue.
+		{code: []byte{0xFF, 0x15, 0x10, 0x00, 0x00, 0x00}, trailing: 1},
+		// push qword ptr [rip+0x10]
+		{code: []byte{0xFF, 0x35, 0x10, 0x00, 0x00, 0x00}},
+		// add rsp, qword ptr [rip+0x10]
+		{code: []byte{0x48, 0x03, 0x25, 0x10, 0x00, 0x00, 0x00}},
+		// mov eax, dword ptr fs:[rip+0x10]
+		{code: []byte{0x64, 0x8B, 0x05, 0x10, 0x00, 0x00, 0x00}},
+	}
+	for _, g := range golden {
+		inst, err := x86asm.Decode(g.code, 64)
+		if err != nil {
+			t.Errorf("% X: unable to decode instruction; %v", g.code, err)
+			continue
+		}
+		end := pc + uint64(inst.Len) + g.trailing
+		if _, err := x64.relocateAsm(inst, pc, end, nil); err == nil {
+			t.Errorf("%q: expected error, got nil", x86asm.IntelSyntax(inst, pc, nil))
+		}
+	}
+}
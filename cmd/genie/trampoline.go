@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// trampolineAsm returns the AT&T syntax assembly of the trampoline of the
+// function at the given address, which executes the original prologue (as
+// overwritten by the injected jmp instruction) and jumps to the remainder of
+// the original function. The hook calls the original function through its
+// trampoline, leaving the patched function intact. PC-relative instructions of
+// the prologue are relocated.
+//
+// The jump back to the original function is absolute, and does not clobber any
+// registers. If tab is non-nil, addresses of the original executable are
+// loaded from the table of runtime addresses.
+func (a *arch) trampolineAsm(prologue []byte, addr uint64, tab *addrTable) ([]string, error) {
+	var asm []string
+	end := addr + uint64(len(prologue))
+	for pc := 0; pc < len(prologue); {
+		inst, err := x86asm.Decode(prologue[pc:], a.mode())
+		if err != nil {
+			return nil, errors.Errorf("unable to decode instruction at address 0x%X; %v", addr+uint64(pc), err)
+		}
+		if inst.PCRel > 0 {
+			// Relocate PC-relative instructions.
+			relocAsm, err := a.relocateAsm(inst, addr+uint64(pc), end, tab)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			asm = append(asm, relocAsm...)
+		} else {
+			asm = append(asm, byteDirective(prologue[pc:pc+inst.Len]))
+		}
+		pc += inst.Len
+	}
+	asm = append(asm, a.jmpAsm(end, tab)...)
+	return asm, nil
+}
+
+// addrTable is the table of runtime addresses referenced by the trampoline of
+// a function when generating ASLR-aware hooks. Addresses of the original
+// executable are recorded relative to its image base (RVAs), and resolved at
+// runtime relative to the base address of the loaded module.
+type addrTable struct {
+	// Symbol name of table.
+	Sym string
+	// Relative addresses of table entries.
+	RVAs []uint64
+	// Preferred image base of original executable.
+	imageBase uint64
+	// Size of table entries in number of bytes.
+	entrySize int
+}
+
+// newAddrTable returns a new table of runtime addresses with the given symbol
+// name, for the given original executable.
+func (a *arch) newAddrTable(sym string, exe executable) *addrTable {
+	return &addrTable{
+		Sym:       sym,
+		imageBase: exe.ImageBase(),
+		entrySize: a.mode() / 8,
+	}
+}
+
+// ref returns the assembly operand (e.g. "foo_addrs_genie+8") of the table
+// entry holding the runtime address of the given address, adding an entry if
+// not yet present.
+func (tab *addrTable) ref(addr uint64) string {
+	rva := addr - tab.imageBase
+	i := 0
+	for ; i < len(tab.RVAs); i++ {
+		if tab.RVAs[i] == rva {
+			break
+		}
+	}
+	if i == len(tab.RVAs) {
+		tab.RVAs = append(tab.RVAs, rva)
+	}
+	return fmt.Sprintf("%s+%d", tab.Sym, i*tab.entrySize)
+}
+
+// byteDirective returns the assembly directive emitting the given bytes.
+func byteDirective(buf []byte) string {
+	var bs []string
+	for _, b := range buf {
+		bs = append(bs, fmt.Sprintf("0x%02X", b))
+	}
+	return ".byte " + strings.Join(bs, ", ")
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTrampolineAsm(t *testing.T) {
+	const addr = 0x401000
+	x86 := &arch{Machine: machine386, JmpSize: 5}
+	x64 := &arch{Machine: machineAMD64, JmpSize: 14}
+	golden := []struct {
+		name     string
+		a        *arch
+		prologue []byte
+		// Load addresses from a table of runtime addresses.
+		tab bool
+		// Expected trampoline; or nil if an error is expected.
+		want []string
+		// Expected relative addresses of table entries.
+		rvas []uint64
+	}{
+		{
+			// push ebp; mov ebp, esp; sub esp, 8
+			name:     "x86",
+			a:        x86,
+			prologue: []byte{0x55, 0x89, 0xE5, 0x83, 0xEC, 0x08},
+			want: []string{
+				".byte 0x55",
+				".byte 0x89, 0xE5",
+				".byte 0x83, 0xEC, 0x08",
+				"pushl $0x401006",
+				"ret",
+			},
+		},
+		{
+			// push rbp; mov rbp, rsp; sub rsp, 0x20; mov [rbp-4], edi;
+			// mov eax, [rbp-4]
+			name:     "x64",
+			a:        x64,
+			prologue: []byte{0x55, 0x48, 0x89, 0xE5, 0x48, 0x83, 0xEC, 0x20, 0x89, 0x7D, 0xFC, 0x8B, 0x45, 0xFC},
+			want: []string{
+				".byte 0x55",
+				".byte 0x48, 0x89, 0xE5",
+				".byte 0x48, 0x83, 0xEC, 0x20",
+				".byte 0x89, 0x7D, 0xFC",
+				".byte 0x8B, 0x45, 0xFC",
+				"jmp *0(%rip)",
+				".quad 0x40100E",
+			},
+		},
+		{
+			// call 0x401015
+			name:     "x86 call",
+			a:        x86,
+			prologue: []byte{0xE8, 0x10, 0x00, 0x00, 0x00},
+			want: []string{
+				"pushl $0x401005",
+				"pushl $0x401015",
+				"ret",
+				"pushl $0x401005",
+				"ret",
+			},
+		},
+		{
+			// mov rax, [rip+0x10]; nop; nop; nop; nop; nop; nop; nop
+			name:     "x64 rip-relative",
+			a:        x64,
+			prologue: []byte{0x48, 0x8B, 0x05, 0x10, 0x00, 0x00, 0x00, 0x90, 0x90, 0x90, 0x90, 0x90, 0x90, 0x90},
+			want: []string{
+				"movabsq $0x401017, %rax",
+				"movq (%rax), %rax",
+				".byte 0x90",
+				".byte 0x90",
+				".byte 0x90",
+				".byte 0x90",
+				".byte 0x90",
+				".byte 0x90",
+				".byte 0x90",
+				"jmp *0(%rip)",
+				".quad 0x40100E",
+			},
+		},
+		{
+			// mov rax, [rip+0x10]; nop; nop; nop; nop; nop; nop; nop
+			name:     "x64 rip-relative with table",
+			a:        x64,
+			prologue: []byte{0x48, 0x8B, 0x05, 0x10, 0x00, 0x00, 0x00, 0x90, 0x90, 0x90, 0x90, 0x90, 0x90, 0x90},
+			tab:      true,
+			want: []string{
+				"movq f_addrs_genie+0(%rip), %rax",
+				"movq (%rax), %rax",
+				".byte 0x90",
+				".byte 0x90",
+				".byte 0x90",
+				".byte 0x90",
+				".byte 0x90",
+				".byte 0x90",
+				".byte 0x90",
+				"jmp *f_addrs_genie+8(%rip)",
+			},
+			rvas: []uint64{0x1017, 0x100E},
+		},
+		{
+			// jmp 0x401010 (thunk)
+			name:     "x86 jmp thunk with table",
+			a:        x86,
+			prologue: []byte{0xE9, 0x0B, 0x00, 0x00, 0x00},
+			tab:      true,
+			want: []string{
+				"jmp *f_addrs_genie+0",
+				"jmp *f_addrs_genie+4",
+			},
+			rvas: []uint64{0x1010, 0x1005},
+		},
+		{
+			// call 0x401016; nop (the callee would return to the prologue)
+			name:     "x86 call within prologue",
+			a:        x86,
+			prologue: []byte{0xE8, 0x10, 0x00, 0x00, 0x00, 0x90},
+		},
+		{
+			// push rbp; push es (invalid in 64-bit mode)
+			name:     "x64 invalid",
+			a:        x64,
+			prologue: []byte{0x55, 0x06},
+		},
+	}
+	for _, g := range golden {
+		var tab *addrTable
+		if g.tab {
+			tab = &addrTable{Sym: "f_addrs_genie", imageBase: 0x400000, entrySize: g.a.mode() / 8}
+		}
+		got, err := g.a.trampolineAsm(g.prologue, addr, tab)
+		if g.want == nil {
+			if err == nil {
+				t.Errorf("%s: expected error, got nil", g.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %+v", g.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, g.want) {
+			t.Errorf("%s: trampoline mismatch; expected %q, got %q", g.name, g.want, got)
+		}
+		if tab != nil && !reflect.DeepEqual(tab.RVAs, g.rvas) {
+			t.Errorf("%s: table mismatch; expected %#x, got %#x", g.name, g.rvas, tab.RVAs)
+		}
+	}
+}
+
+func TestAddrTableRef(t *testing.T) {
+	tab := &addrTable{Sym: "f_addrs_genie", imageBase: 0x400000, entrySize: 8}
+	golden := []struct {
+		addr uint64
+		want string
+	}{
+		{addr: 0x401000, want: "f_addrs_genie+0"},
+		{addr: 0x402000, want: "f_addrs_genie+8"},
+		// Entries are reused.
+		{addr: 0x401000, want: "f_addrs_genie+0"},
+		{addr: 0x400000, want: "f_addrs_genie+16"},
+	}
+	for _, g := range golden {
+		if got := tab.ref(g.addr); got != g.want {
+			t.Errorf("0x%X: operand mismatch; expected %q, got %q", g.addr, g.want, got)
+		}
+	}
+	want := []uint64{0x1000, 0x2000, 0}
+	if !reflect.DeepEqual(tab.RVAs, want) {
+		t.Errorf("table mismatch; expected %#x, got %#x", want, tab.RVAs)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/types"
+	"github.com/mewmew/genie/mdutil"
+	"github.com/pkg/errors"
+)
+
+// userCall is an IDA-style custom calling convention of a function, specifying
+// the register or stack location of each parameter and of the return value.
+//
+// The calling convention of a stub is annotated by storing a string of the
+// form "RET(LOC, ...)" to a local variable named `usercall` (caller purges
+// stack arguments) or `userpurge` (callee purges stack arguments), where RET is
+// the register of the return value (may be omitted for void and EAX) and each
+// LOC is either a register or "stack". IDA-style locations (e.g. "@<esi>") are
+// also accepted.
+//
+// Example:
+//
+//	int foo(int a, int b, int c) {
+//	   int addr = 0x401230;
+//	   char *usercall = "eax(eax, esi, stack)";
+//	}
+//
+// Hooks of user calling convention functions are entered through a naked asm
+// thunk (named after the function), which marshals the arguments into a C call
+// of the hook. Similarly, the original function is called from the hook through
+// a naked asm thunk, which marshals the arguments of the C call into their
+// original locations before calling the trampoline of the original function.
+type userCall struct {
+	// Calling convention keyword; "usercall" or "userpurge".
+	Keyword string
+	// Callee purges stack arguments.
+	Purge bool
+	// Register of return value; empty if returned in EAX or void.
+	RetReg string
+	// Location of each parameter.
+	Params []userCallParam
+}
+
+// userCallParam is the location of a user calling convention parameter.
+type userCallParam struct {
+	// Register of parameter; empty if passed on the stack.
+	Reg string
+	// Size of parameter in number of 4-byte stack slots.
+	Slots int
+}
+
+// userCallRegs specifies the registers which may hold user calling convention
+// parameters and return values. EBP and ESP are used by the thunks.
+var userCallRegs = map[string]bool{
+	"eax": true,
+	"ebx": true,
+	"ecx": true,
+	"edx": true,
+	"esi": true,
+	"edi": true,
+}
+
+// parseUserCall parses the user calling convention annotation of the given
+// function, if present. A nil userCall is returned if no annotation is present.
+func parseUserCall(f *ir.Func, locals []mdutil.Var) (*userCall, error) {
+	for _, keyword := range []string{"usercall", "userpurge"} {
+		if !hasLocal(locals, keyword) {
+			continue
+		}
+		s, err := parseString(f, locals, keyword)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		uc, err := newUserCall(f, s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s annotation %q of function %q", keyword, s, f.Name())
+		}
+		uc.Keyword = keyword
+		uc.Purge = keyword == "userpurge"
+		return uc, nil
+	}
+	return nil, nil
+}
+
+// newUserCall returns the user calling convention of the given function, as
+// specified by the annotation string s.
+func newUserCall(f *ir.Func, s string) (*userCall, error) {
+	// Accept IDA-style locations (e.g. "@<esi>").
+	s = strings.NewReplacer("@", "", "<", "", ">", "").Replace(s)
+	s = strings.ToLower(strings.TrimSpace(s))
+	start := strings.Index(s, "(")
+	if start == -1 || !strings.HasSuffix(s, ")") {
+		return nil, errors.New(`expected "RET(LOC, ...)"`)
+	}
+	uc := &userCall{}
+	// Parse return value location.
+	retReg := strings.TrimSpace(s[:start])
+	switch {
+	case len(retReg) == 0, retReg == "eax":
+		// Return value in EAX (or void).
+	case !userCallRegs[retReg]:
+		return nil, errors.Errorf("invalid return value register %q", retReg)
+	case isWide(f.Sig.RetType):
+		return nil, errors.Errorf("64-bit return value in register %q not supported", retReg)
+	default:
+		uc.RetReg = retReg
+	}
+	// Parse parameter locations.
+	var locs []string
+	if list := strings.TrimSpace(s[start+1 : len(s)-1]); len(list) > 0 {
+		locs = strings.Split(list, ",")
+	}
+	if len(locs) != len(f.Params) {
+		return nil, errors.Errorf("parameter location count mismatch; expected %d, got %d", len(f.Params), len(locs))
+	}
+	for i, loc := range locs {
+		param := f.Params[i]
+		slots, err := stackSlots(param)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		p := userCallParam{Slots: slots}
+		switch loc = strings.TrimSpace(loc); {
+		case loc == "stack":
+		case !userCallRegs[loc]:
+			return nil, errors.Errorf("invalid register %q of parameter %q", loc, param.Name())
+		case slots != 1:
+			return nil, errors.Errorf("parameter %q does not fit in register %q", param.Name(), loc)
+		default:
+			p.Reg = loc
+		}
+		uc.Params = append(uc.Params, p)
+	}
+	return uc, nil
+}
+
+// stackSlots returns the size of the given parameter in number of 4-byte stack
+// slots.
+func stackSlots(param *ir.Param) (int, error) {
+	switch t := param.Typ.(type) {
+	case *types.IntType:
+		return int(t.BitSize+31) / 32, nil
+	case *types.PointerType:
+		return 1, nil
+	case *types.FloatType:
+		if t.Kind == types.FloatKindFloat {
+			return 1, nil
+		}
+		if t.Kind == types.FloatKindDouble {
+			return 2, nil
+		}
+	}
+	return 0, errors.Errorf("support for parameter %q of type %v not yet implemented", param.Name(), param.Typ)
+}
+
+// isWide reports whether the given type is wider than 32 bits.
+func isWide(t types.Type) bool {
+	switch t := t.(type) {
+	case *types.IntType:
+		return t.BitSize > 32
+	case *types.FloatType:
+		return t.Kind != types.FloatKindFloat
+	}
+	return false
+}
+
+// stackSize returns the total size in bytes of stack arguments.
+func (uc *userCall) stackSize() int {
+	n := 0
+	for _, p := range uc.Params {
+		if len(p.Reg) == 0 {
+			n += 4 * p.Slots
+		}
+	}
+	return n
+}
+
+// EntryAsm returns the AT&T syntax assembly of the naked entry thunk of the
+// hook, which converts the user calling convention into a cdecl call of the
+// hook function (referenced by asm operand %0).
+func (uc *userCall) EntryAsm() []string {
+	var asm []string
+	asm = append(asm, "pushl %%ebp", "movl %%esp, %%ebp")
+	// Offsets of stack arguments relative to EBP.
+	offsets := make([]int, len(uc.Params))
+	offset := 8
+	for i, p := range uc.Params {
+		if len(p.Reg) == 0 {
+			offsets[i] = offset
+			offset += 4 * p.Slots
+		}
+	}
+	// Push arguments of the cdecl call in reverse order.
+	cdeclSize := 0
+	for i := len(uc.Params) - 1; i >= 0; i-- {
+		p := uc.Params[i]
+		if len(p.Reg) > 0 {
+			asm = append(asm, fmt.Sprintf("pushl %%%%%s", p.Reg))
+			cdeclSize += 4
+			continue
+		}
+		for slot := p.Slots - 1; slot >= 0; slot-- {
+			asm = append(asm, fmt.Sprintf("pushl %d(%%%%ebp)", offsets[i]+4*slot))
+			cdeclSize += 4
+		}
+	}
+	asm = append(asm, "call %c0")
+	if cdeclSize > 0 {
+		asm = append(asm, fmt.Sprintf("addl $%d, %%%%esp", cdeclSize))
+	}
+	if len(uc.RetReg) > 0 {
+		asm = append(asm, fmt.Sprintf("movl %%%%eax, %%%%%s", uc.RetReg))
+	}
+	asm = append(asm, "popl %%ebp")
+	asm = append(asm, uc.retAsm(uc.Purge))
+	return asm
+}
+
+// OrigAsm returns the AT&T syntax assembly of the naked thunk of the original
+// function, which converts a cdecl call into a call of the trampoline of the
+// original function (referenced by asm operand %0) using the user calling
+// convention.
+func (uc *userCall) OrigAsm() []string {
+	var asm []string
+	asm = append(asm, "pushl %%ebp", "movl %%esp, %%ebp")
+	// Preserve callee-saved registers, as they may be used for arguments.
+	asm = append(asm, "pushl %%ebx", "pushl %%esi", "pushl %%edi")
+	// Offsets of cdecl arguments relative to EBP.
+	offsets := make([]int, len(uc.Params))
+	offset := 8
+	for i, p := range uc.Params {
+		offsets[i] = offset
+		offset += 4 * p.Slots
+	}
+	// Push stack arguments in reverse order.
+	for i := len(uc.Params) - 1; i >= 0; i-- {
+		p := uc.Params[i]
+		if len(p.Reg) > 0 {
+			continue
+		}
+		for slot := p.Slots - 1; slot >= 0; slot-- {
+			asm = append(asm, fmt.Sprintf("pushl %d(%%%%ebp)", offsets[i]+4*slot))
+		}
+	}
+	// Load register arguments.
+	for i, p := range uc.Params {
+		if len(p.Reg) > 0 {
+			asm = append(asm, fmt.Sprintf("movl %d(%%%%ebp), %%%%%s", offsets[i], p.Reg))
+		}
+	}
+	asm = append(asm, "call %c0")
+	if size := uc.stackSize(); !uc.Purge && size > 0 {
+		asm = append(asm, fmt.Sprintf("addl $%d, %%%%esp", size))
+	}
+	if len(uc.RetReg) > 0 {
+		asm = append(asm, fmt.Sprintf("movl %%%%%s, %%%%eax", uc.RetReg))
+	}
+	asm = append(asm, "popl %%edi", "popl %%esi", "popl %%ebx", "popl %%ebp")
+	asm = append(asm, uc.retAsm(false))
+	return asm
+}
+
+// retAsm returns the return instruction of a thunk, purging stack arguments if
+// purge is set.
+func (uc *userCall) retAsm(purge bool) string {
+	if size := uc.stackSize(); purge && size > 0 {
+		return fmt.Sprintf("ret $%d", size)
+	}
+	return "ret"
+}
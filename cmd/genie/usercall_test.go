@@ -0,0 +1,122 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/types"
+)
+
+func TestNewUserCall(t *testing.T) {
+	// f(int a, int b, int c)
+	f := ir.NewFunc("f", types.I32, ir.NewParam("a", types.I32), ir.NewParam("b", types.I32), ir.NewParam("c", types.I32))
+	// g(long long a, int b)
+	g := ir.NewFunc("g", types.I64, ir.NewParam("a", types.I64), ir.NewParam("b", types.I32))
+	golden := []struct {
+		f    *ir.Func
+		s    string
+		want *userCall
+		err  bool
+	}{
+		{
+			f: f, s: "eax(eax, esi, stack)",
+			want: &userCall{Params: []userCallParam{{Reg: "eax", Slots: 1}, {Reg: "esi", Slots: 1}, {Slots: 1}}},
+		},
+		{
+			f: f, s: "(ecx, edx, stack)",
+			want: &userCall{Params: []userCallParam{{Reg: "ecx", Slots: 1}, {Reg: "edx", Slots: 1}, {Slots: 1}}},
+		},
+		{
+			f: f, s: "@<esi>(@<eax>, @<EBX>, stack)",
+			want: &userCall{RetReg: "esi", Params: []userCallParam{{Reg: "eax", Slots: 1}, {Reg: "ebx", Slots: 1}, {Slots: 1}}},
+		},
+		{
+			f: g, s: "(stack, edi)",
+			want: &userCall{Params: []userCallParam{{Slots: 2}, {Reg: "edi", Slots: 1}}},
+		},
+		// Missing parameter list.
+		{f: f, s: "eax", err: true},
+		// Parameter count mismatch.
+		{f: f, s: "(eax, esi)", err: true},
+		// Invalid register.
+		{f: f, s: "(eax, esp, stack)", err: true},
+		{f: f, s: "ebp(eax, esi, stack)", err: true},
+		// 64-bit parameter and return value in register.
+		{f: g, s: "(eax, edi)", err: true},
+		{f: g, s: "esi(stack, edi)", err: true},
+	}
+	for _, gg := range golden {
+		uc, err := newUserCall(gg.f, gg.s)
+		if gg.err {
+			if err == nil {
+				t.Errorf("%q: expected error, got nil", gg.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %+v", gg.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(uc, gg.want) {
+			t.Errorf("%q: user calling convention mismatch; expected %#v, got %#v", gg.s, gg.want, uc)
+		}
+	}
+}
+
+func TestUserCallAsm(t *testing.T) {
+	params := []userCallParam{{Reg: "eax", Slots: 1}, {Reg: "esi", Slots: 1}, {Slots: 1}}
+	golden := []struct {
+		uc        *userCall
+		wantEntry []string
+		wantOrig  []string
+	}{
+		// int __usercall f@<eax>(int a@<eax>, int b@<esi>, int c)
+		{
+			uc: &userCall{Keyword: "usercall", Params: params},
+			wantEntry: []string{
+				"pushl %%ebp", "movl %%esp, %%ebp",
+				"pushl 8(%%ebp)", "pushl %%esi", "pushl %%eax",
+				"call %c0", "addl $12, %%esp",
+				"popl %%ebp", "ret",
+			},
+			wantOrig: []string{
+				"pushl %%ebp", "movl %%esp, %%ebp",
+				"pushl %%ebx", "pushl %%esi", "pushl %%edi",
+				"pushl 16(%%ebp)",
+				"movl 8(%%ebp), %%eax", "movl 12(%%ebp), %%esi",
+				"call %c0",
+				"addl $4, %%esp",
+				"popl %%edi", "popl %%esi", "popl %%ebx", "popl %%ebp", "ret",
+			},
+		},
+		// int __userpurge f@<edi>(int a@<eax>, int b@<esi>, int c)
+		{
+			uc: &userCall{Keyword: "userpurge", Purge: true, RetReg: "edi", Params: params},
+			wantEntry: []string{
+				"pushl %%ebp", "movl %%esp, %%ebp",
+				"pushl 8(%%ebp)", "pushl %%esi", "pushl %%eax",
+				"call %c0", "addl $12, %%esp",
+				"movl %%eax, %%edi",
+				"popl %%ebp", "ret $4",
+			},
+			wantOrig: []string{
+				"pushl %%ebp", "movl %%esp, %%ebp",
+				"pushl %%ebx", "pushl %%esi", "pushl %%edi",
+				"pushl 16(%%ebp)",
+				"movl 8(%%ebp), %%eax", "movl 12(%%ebp), %%esi",
+				"call %c0",
+				"movl %%edi, %%eax",
+				"popl %%edi", "popl %%esi", "popl %%ebx", "popl %%ebp", "ret",
+			},
+		},
+	}
+	for _, g := range golden {
+		if got := g.uc.EntryAsm(); !reflect.DeepEqual(got, g.wantEntry) {
+			t.Errorf("%s: entry thunk mismatch; expected %q, got %q", g.uc.Keyword, g.wantEntry, got)
+		}
+		if got := g.uc.OrigAsm(); !reflect.DeepEqual(got, g.wantOrig) {
+			t.Errorf("%s: original thunk mismatch; expected %q, got %q", g.uc.Keyword, g.wantOrig, got)
+		}
+	}
+}
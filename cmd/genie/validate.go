@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// resolveSyms resolves the addresses of the given functions located by symbol
+// name, as exported by the original executable, and the import address table
+// slots of imported functions. Every unresolved symbol is reported together.
+func resolveSyms(funcs []*hookedFunc, exe executable) error {
+	var unresolved []string
+	for _, fn := range funcs {
+		if fn.imp != nil {
+			slot, err := exe.ImportSlot(fn.imp)
+			if err != nil {
+				unresolved = append(unresolved, fmt.Sprintf("function %q; %v", fn.name, err))
+				continue
+			}
+			fn.slot = slot
+			continue
+		}
+		if len(fn.sym) == 0 {
+			continue
+		}
+		addr, err := exe.Lookup(fn.sym)
+		if err != nil {
+			unresolved = append(unresolved, fmt.Sprintf("function %q; %v", fn.name, err))
+			continue
+		}
+		fn.addr = addr
+	}
+	if len(unresolved) > 0 {
+		return errors.Errorf("unable to resolve symbols:\n\t%s", strings.Join(unresolved, "\n\t"))
+	}
+	return nil
+}
+
+// checkHooks validates the addresses of the given functions to hook against the
+// section table of the original executable, and records the size of the patch
+// of each function. Hook addresses must be located within executable sections,
+// be unique, and not be located within the patch of another function. Import
+// address table slots of imported functions must be unique. Every violation is
+// reported together.
+func checkHooks(funcs []*hookedFunc, exe executable, a *arch) error {
+	var violations []string
+	var patched []*hookedFunc
+	slots := make(map[uint64]*hookedFunc)
+	for _, fn := range funcs {
+		if fn.imp != nil {
+			if prev, ok := slots[fn.slot]; ok {
+				violations = append(violations, fmt.Sprintf("import %q of function %q duplicates import of function %q", fn.imp, fn.name, prev.name))
+				continue
+			}
+			slots[fn.slot] = fn
+			continue
+		}
+		patched = append(patched, fn)
+		sect := sectionOf(exe, fn.addr)
+		switch {
+		case sect == nil:
+			violations = append(violations, fmt.Sprintf("address %s of function %q not located within a section", a.addrString(fn.addr), fn.name))
+			continue
+		case !sect.Exec:
+			violations = append(violations, fmt.Sprintf("address %s of function %q located within non-executable section %q", a.addrString(fn.addr), fn.name, sect.Name))
+			continue
+		}
+		patchSize, err := a.patchSize(exe, fn.addr)
+		if err != nil {
+			violations = append(violations, fmt.Sprintf("function %q; %v", fn.name, err))
+			continue
+		}
+		fn.patchSize = patchSize
+	}
+	// Check for duplicate and overlapping hooks, in order of address.
+	sorted := make([]*hookedFunc, len(patched))
+	copy(sorted, patched)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].addr < sorted[j].addr
+	})
+	for i, fn := range sorted {
+		for _, prev := range sorted[:i] {
+			// Functions with invalid addresses have already been reported;
+			// assume the minimum patch size.
+			size := prev.patchSize
+			if size == 0 {
+				size = a.JmpSize
+			}
+			switch {
+			case fn.addr == prev.addr:
+				violations = append(violations, fmt.Sprintf("address %s of function %q duplicates address of function %q", a.addrString(fn.addr), fn.name, prev.name))
+			case fn.addr < prev.addr+uint64(size):
+				violations = append(violations, fmt.Sprintf("address %s of function %q located within the %d-byte patch of function %q at address %s", a.addrString(fn.addr), fn.name, size, prev.name, a.addrString(prev.addr)))
+			default:
+				continue
+			}
+			break
+		}
+	}
+	if len(violations) > 0 {
+		return errors.Errorf("invalid hook addresses:\n\t%s", strings.Join(violations, "\n\t"))
+	}
+	return nil
+}
+
+// sectionOf returns the section of the given executable containing the
+// specified address; or nil if not present.
+func sectionOf(exe executable, addr uint64) *section {
+	for _, sect := range exe.Sections() {
+		if sect.Addr <= addr && addr < sect.Addr+uint64(len(sect.Data)) {
+			return sect
+		}
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCheckHooks(t *testing.T) {
+	// push ebp; mov ebp, esp; sub esp, 0x10; leave; ret
+	frame := []byte{0x55, 0x89, 0xE5, 0x83, 0xEC, 0x10, 0xC9, 0xC3}
+	var text []byte
+	text = append(text, frame...)               // 0x401000
+	text = append(text, frame...)               // 0x401008
+	text = append(text, 0xC3, 0xCC, 0xCC, 0xCC) // 0x401010: ret
+	exe := &elfExecutable{
+		machine: machine386,
+		sects: []*section{
+			{Name: ".text", Addr: 0x401000, Size: uint64(len(text)), Data: text, Exec: true},
+			{Name: ".data", Addr: 0x402000, Size: 0x10, Data: make([]byte, 0x10)},
+		},
+	}
+	a := &arch{Machine: machine386, JmpSize: 5, AddrDigits: 6}
+	golden := []struct {
+		name  string
+		addrs []uint64
+		// Expected patch sizes; or nil if an error is expected.
+		want []int64
+		// Expected substring of error.
+		err string
+	}{
+		{name: "valid", addrs: []uint64{0x401008, 0x401000}, want: []int64{6, 6}},
+		{name: "outside section", addrs: []uint64{0x500000}, err: "address 0x500000 of function \"f0\" not located within a section"},
+		{name: "non-executable", addrs: []uint64{0x402000}, err: "located within non-executable section \".data\""},
+		{name: "too short", addrs: []uint64{0x401010}, err: "function \"f0\"; function at address 0x401010 ends within the 5-byte jmp instruction"},
+		{name: "duplicate", addrs: []uint64{0x401000, 0x401000}, err: "address 0x401000 of function \"f1\" duplicates address of function \"f0\""},
+		{name: "overlap", addrs: []uint64{0x401003, 0x401000}, err: "address 0x401003 of function \"f0\" located within the 6-byte patch of function \"f1\" at address 0x401000"},
+	}
+	for _, g := range golden {
+		var funcs []*hookedFunc
+		for i, addr := range g.addrs {
+			funcs = append(funcs, &hookedFunc{name: fmt.Sprintf("f%d", i), addr: addr})
+		}
+		err := checkHooks(funcs, exe, a)
+		if len(g.err) > 0 {
+			if err == nil {
+				t.Errorf("%s: expected error, got nil", g.name)
+			} else if !strings.Contains(err.Error(), g.err) {
+				t.Errorf("%s: error mismatch; expected %q in %q", g.name, g.err, err.Error())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %+v", g.name, err)
+			continue
+		}
+		for i, fn := range funcs {
+			if fn.patchSize != g.want[i] {
+				t.Errorf("%s: patch size mismatch of %q; expected %d, got %d", g.name, fn.name, g.want[i], fn.patchSize)
+			}
+		}
+	}
+}
+
+func TestCheckHooksImports(t *testing.T) {
+	// push ebp; mov ebp, esp; sub esp, 0x10; leave; ret
+	text := []byte{0x55, 0x89, 0xE5, 0x83, 0xEC, 0x10, 0xC9, 0xC3}
+	exe := &peExecutable{
+		machine: machine386,
+		sects: []*section{
+			{Name: ".text", Addr: 0x401000, Size: uint64(len(text)), Data: text, Exec: true},
+		},
+	}
+	a := &arch{Machine: machine386, Windows: true, JmpSize: 5, AddrDigits: 6}
+	puts := &importRef{DLL: "msvcrt.dll", Sym: "puts"}
+	golden := []struct {
+		name  string
+		funcs []*hookedFunc
+		// Expected substring of error; or empty if valid.
+		err string
+	}{
+		{
+			// Import address table slots are not located within executable
+			// sections, and do not overlap patched functions.
+			name: "valid",
+			funcs: []*hookedFunc{
+				{name: "f", addr: 0x401000},
+				{name: "g", imp: puts, slot: 0x403000},
+				{name: "h", imp: &importRef{DLL: "msvcrt.dll", Sym: "printf"}, slot: 0x403004},
+			},
+		},
+		{
+			name: "duplicate import",
+			funcs: []*hookedFunc{
+				{name: "g", imp: puts, slot: 0x403000},
+				{name: "h", imp: &importRef{DLL: "MSVCRT.dll", Sym: "puts"}, slot: 0x403000},
+			},
+			err: `import "MSVCRT.dll!puts" of function "h" duplicates import of function "g"`,
+		},
+	}
+	for _, g := range golden {
+		err := checkHooks(g.funcs, exe, a)
+		if len(g.err) > 0 {
+			if err == nil {
+				t.Errorf("%s: expected error, got nil", g.name)
+			} else if !strings.Contains(err.Error(), g.err) {
+				t.Errorf("%s: error mismatch; expected %q in %q", g.name, g.err, err.Error())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %+v", g.name, err)
+		}
+	}
+}
+
+func TestSectionOf(t *testing.T) {
+	exe := &elfExecutable{
+		machine: machine386,
+		sects: []*section{
+			{Name: ".text", Addr: 0x401000, Data: make([]byte, 0x100)},
+			{Name: ".data", Addr: 0x402000, Data: make([]byte, 0x10)},
+		},
+	}
+	golden := []struct {
+		addr uint64
+		// Expected section name; or empty if not present.
+		want string
+	}{
+		{addr: 0x401000, want: ".text"},
+		{addr: 0x4010FF, want: ".text"},
+		{addr: 0x401100},
+		{addr: 0x40200F, want: ".data"},
+		{addr: 0x400000},
+	}
+	for _, g := range golden {
+		var got string
+		if sect := sectionOf(exe, g.addr); sect != nil {
+			got = sect.Name
+		}
+		if got != g.want {
+			t.Errorf("0x%X: section mismatch; expected %q, got %q", g.addr, g.want, got)
+		}
+	}
+}
+
+func TestResolveSyms(t *testing.T) {
+	exe := &elfExecutable{
+		machine: machineAMD64,
+		dynsyms: map[string]uint64{"foo": 0x401000, "bar": 0x401020},
+	}
+	golden := []struct {
+		name string
+		fn   *hookedFunc
+		// Expected address; or zero if an error is expected.
+		want uint64
+	}{
+		{name: "symbol", fn: &hookedFunc{name: "f", sym: "foo"}, want: 0x401000},
+		{name: "address", fn: &hookedFunc{name: "f", addr: 0x402000}, want: 0x402000},
+		{name: "missing symbol", fn: &hookedFunc{name: "f", sym: "baz"}},
+		{name: "ordinal", fn: &hookedFunc{name: "f", sym: "#3"}},
+		// Import address table hooks are not supported by ELF.
+		{name: "import", fn: &hookedFunc{name: "f", imp: &importRef{DLL: "libc.so.6", Sym: "puts"}}},
+	}
+	for _, g := range golden {
+		err := resolveSyms([]*hookedFunc{g.fn}, exe)
+		if g.want == 0 {
+			if err == nil {
+				t.Errorf("%s: expected error, got nil", g.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %+v", g.name, err)
+			continue
+		}
+		if g.fn.addr != g.want {
+			t.Errorf("%s: address mismatch; expected 0x%X, got 0x%X", g.name, g.want, g.fn.addr)
+		}
+	}
+	// Every unresolved symbol is reported.
+	funcs := []*hookedFunc{{name: "f", sym: "baz"}, {name: "g", sym: "bar"}, {name: "h", sym: "qux"}}
+	err := resolveSyms(funcs, exe)
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	for _, want := range []string{`function "f"`, `function "h"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in %q", want, err.Error())
+		}
+	}
+	if funcs[1].addr != 0x401020 {
+		t.Errorf("address mismatch; expected 0x401020, got 0x%X", funcs[1].addr)
+	}
+}
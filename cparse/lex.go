@@ -0,0 +1,137 @@
+package cparse
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// tokenKind is the kind of a C token.
+type tokenKind uint8
+
+// Token kinds.
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenPunct
+)
+
+// token is a C token.
+type token struct {
+	// Token kind.
+	kind tokenKind
+	// Token text.
+	text string
+	// Line number (1-based).
+	line int
+}
+
+// puncts specifies the punctuators of C recognized by the lexer, longest
+// first.
+var puncts = []string{
+	"...", "<<", ">>",
+	"*", "(", ")", "[", "]", "{", "}", ",", ";", ":", "@", "=",
+	"+", "-", "~", "|", "&", "^", "/", "%",
+}
+
+// lex returns the tokens of the given C source code, terminated by an EOF
+// token. Comments and preprocessor directives are skipped.
+func lex(name, src string) ([]token, error) {
+	var toks []token
+	line := 1
+	// Start of line; only whitespace since the last line break.
+	bol := true
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			bol = true
+			i++
+			continue
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			i++
+			continue
+		case c == '#' && bol:
+			// Skip preprocessor directive, including line continuations.
+			for i < len(src) && src[i] != '\n' {
+				if src[i] == '\\' && i+1 < len(src) && src[i+1] == '\n' {
+					line++
+					i++
+				}
+				i++
+			}
+			continue
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			continue
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end == -1 {
+				return nil, errors.Errorf("%s:%d: unterminated comment", name, line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += 2 + end + 2
+			continue
+		}
+		bol = false
+		switch {
+		case isIdentStart(c):
+			start := i
+			for i < len(src) && (isIdentStart(src[i]) || isDigit(src[i])) {
+				i++
+			}
+			toks = append(toks, token{kind: tokenIdent, text: src[start:i], line: line})
+		case isDigit(c):
+			start := i
+			for i < len(src) && (isIdentStart(src[i]) || isDigit(src[i])) {
+				i++
+			}
+			toks = append(toks, token{kind: tokenNumber, text: src[start:i], line: line})
+		case c == '"':
+			start := i
+			for i++; i < len(src) && src[i] != '"'; i++ {
+				if src[i] == '\\' {
+					i++
+				}
+				if i < len(src) && src[i] == '\n' {
+					return nil, errors.Errorf("%s:%d: unterminated string literal", name, line)
+				}
+			}
+			if i >= len(src) {
+				return nil, errors.Errorf("%s:%d: unterminated string literal", name, line)
+			}
+			i++
+			toks = append(toks, token{kind: tokenString, text: src[start:i], line: line})
+		default:
+			punct := ""
+			for _, p := range puncts {
+				if strings.HasPrefix(src[i:], p) {
+					punct = p
+					break
+				}
+			}
+			if len(punct) == 0 {
+				return nil, errors.Errorf("%s:%d: unexpected character %q", name, line, c)
+			}
+			toks = append(toks, token{kind: tokenPunct, text: punct, line: line})
+			i += len(punct)
+		}
+	}
+	toks = append(toks, token{kind: tokenEOF, line: line})
+	return toks, nil
+}
+
+// isIdentStart reports whether the given character may start an identifier.
+func isIdentStart(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// isDigit reports whether the given character is a decimal digit.
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
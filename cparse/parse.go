@@ -0,0 +1,905 @@
+// Package cparse parses C headers of function prototypes annotated with the
+// addresses of the functions into C types.
+//
+// Example:
+//
+//	typedef struct {
+//		int x;
+//		int y;
+//	} point;
+//
+//	int __stdcall foo(point *p, const char *s) @ 0x401230;
+//
+// Functions exported by the original executable may be annotated with their
+// symbol name (or an ordinal of the form "#N") instead of their address.
+//
+//	int __stdcall bar(int x) @ "bar";
+//
+// Imported functions, hooked through their import address table slot, are
+// annotated with the DLL and import name (or ordinal).
+//
+//	int my_puts(const char *s) @ iat "msvcrt.dll!puts";
+//
+// Preprocessor directives are skipped, and macros are thus not expanded.
+package cparse
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/mewmew/genie/ctype"
+	"github.com/pkg/errors"
+)
+
+// Func is a C function prototype annotated with the address of the function.
+type Func struct {
+	// Function name.
+	Name string
+	// Function type, including parameter names.
+	Type *ctype.FuncType
+	// Function address; or zero if annotated with a symbol name.
+	Addr uint64
+	// Symbol name of function; or empty if annotated with an address.
+	Sym string
+	// Imported function of the form "DLL!NAME"; or empty if not annotated
+	// with `@ iat`.
+	Import string
+}
+
+// ParseFile parses the given C header, returning the annotated function
+// prototypes in order of declaration.
+func ParseFile(path string) ([]*Func, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return Parse(path, buf)
+}
+
+// Parse parses the given C source code, returning the annotated function
+// prototypes in order of declaration. The name is used in error messages.
+func Parse(name string, src []byte) ([]*Func, error) {
+	toks, err := lex(name, string(src))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	p := &parser{
+		name:       name,
+		toks:       toks,
+		typedefs:   make(map[string]*ctype.Typedef),
+		tags:       make(map[string]ctype.Type),
+		enumValues: make(map[string]int64),
+	}
+	for p.peek().kind != tokenEOF {
+		if err := p.externalDecl(); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return p.funcs, nil
+}
+
+// basicTypes maps from type specifiers to basic types.
+var basicTypes = make(map[string]ctype.BasicType)
+
+// callConvs maps from calling convention keywords to calling conventions.
+var callConvs = make(map[string]ctype.CallingConv)
+
+func init() {
+	for t := ctype.BasicTypeVoid; t <= ctype.BasicTypeUInt128; t++ {
+		basicTypes[t.String()] = t
+	}
+	for cc := ctype.CallConvFastCall; cc <= ctype.CallConvVectorCall; cc++ {
+		callConvs[cc.String()] = cc
+	}
+}
+
+// basicTypeKeywords specifies the keywords of basic type specifiers.
+var basicTypeKeywords = map[string]bool{
+	"void":     true,
+	"char":     true,
+	"short":    true,
+	"int":      true,
+	"long":     true,
+	"signed":   true,
+	"unsigned": true,
+	"float":    true,
+	"double":   true,
+	"_Bool":    true,
+	"_Complex": true,
+	"wchar_t":  true,
+	"char16_t": true,
+	"char32_t": true,
+	"__int128": true,
+}
+
+// ignoredKeywords specifies the keywords of storage classes, function
+// specifiers and type qualifiers which do not affect the types of functions
+// and parameters.
+var ignoredKeywords = map[string]bool{
+	"extern":     true,
+	"static":     true,
+	"inline":     true,
+	"__inline":   true,
+	"register":   true,
+	"volatile":   true,
+	"restrict":   true,
+	"__restrict": true,
+}
+
+// parser is a parser of C function prototypes.
+type parser struct {
+	// Source name, used in error messages.
+	name string
+	// Tokens of the source code.
+	toks []token
+	// Current token index.
+	pos int
+	// Annotated function prototypes.
+	funcs []*Func
+	// Type definitions, by type name.
+	typedefs map[string]*ctype.Typedef
+	// Tagged types, by type specifier (e.g. "struct foo").
+	tags map[string]ctype.Type
+	// Enumerator values, by enumerator name.
+	enumValues map[string]int64
+}
+
+// externalDecl parses a type definition, a structure, union or enum
+// definition, or an annotated function prototype.
+func (p *parser) externalDecl() error {
+	if p.accept(";") {
+		return nil
+	}
+	typedef := p.accept("typedef")
+	base, cc, err := p.specifiers()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if p.accept(";") {
+		return nil
+	}
+	for {
+		tok := p.peek()
+		name, t, err := p.declarator(base, cc)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if len(name) == 0 {
+			return p.errorf(tok, "missing identifier of declaration")
+		}
+		switch {
+		case typedef:
+			p.typedefs[name] = &ctype.Typedef{Name: name, Typ: t}
+		default:
+			funcType, ok := t.(*ctype.FuncType)
+			if !ok {
+				return p.errorf(tok, "invalid declaration of %q; expected function prototype", name)
+			}
+			if !p.accept("@") {
+				return p.errorf(p.peek(), "missing address of function %q; expected `@ ADDR` or `@ \"SYM\"`", name)
+			}
+			fn := &Func{Name: name, Type: funcType}
+			addrTok := p.next()
+			if addrTok.kind == tokenIdent && addrTok.text == "iat" {
+				impTok := p.next()
+				imp, err := strconv.Unquote(impTok.text)
+				if impTok.kind != tokenString || err != nil || len(imp) == 0 {
+					return p.errorf(impTok, "invalid imported function %q of function %q; expected `@ iat \"DLL!NAME\"`", impTok.text, name)
+				}
+				fn.Import = imp
+			} else if addrTok.kind == tokenString {
+				sym, err := strconv.Unquote(addrTok.text)
+				if err != nil || len(sym) == 0 {
+					return p.errorf(addrTok, "invalid symbol name %s of function %q", addrTok.text, name)
+				}
+				fn.Sym = sym
+			} else {
+				addr, err := parseUint(addrTok.text)
+				if addrTok.kind != tokenNumber || err != nil {
+					return p.errorf(addrTok, "invalid address %q of function %q", addrTok.text, name)
+				}
+				fn.Addr = addr
+			}
+			p.funcs = append(p.funcs, fn)
+		}
+		if !p.accept(",") {
+			break
+		}
+	}
+	return p.expect(";")
+}
+
+// specifiers parses declaration specifiers, returning the base type and the
+// calling convention (or zero if not specified).
+func (p *parser) specifiers() (ctype.Type, ctype.CallingConv, error) {
+	var (
+		t        ctype.Type
+		cc       ctype.CallingConv
+		isConst  bool
+		keywords []string
+	)
+	start := p.peek()
+loop:
+	for {
+		tok := p.peek()
+		if tok.kind != tokenIdent {
+			break
+		}
+		switch {
+		case tok.text == "const":
+			p.next()
+			isConst = true
+		case ignoredKeywords[tok.text]:
+			p.next()
+		case basicTypeKeywords[tok.text]:
+			p.next()
+			keywords = append(keywords, tok.text)
+		case tok.text == "struct" || tok.text == "union" || tok.text == "enum":
+			if t != nil || len(keywords) > 0 {
+				return nil, 0, p.errorf(tok, "multiple types in declaration specifiers")
+			}
+			tagged, err := p.tagged()
+			if err != nil {
+				return nil, 0, errors.WithStack(err)
+			}
+			t = tagged
+		case p.typedefs[tok.text] != nil && t == nil && len(keywords) == 0:
+			p.next()
+			t = p.typedefs[tok.text]
+		default:
+			c, ok, err := p.callConv()
+			if err != nil {
+				return nil, 0, errors.WithStack(err)
+			}
+			if !ok {
+				break loop
+			}
+			if c != 0 {
+				cc = c
+			}
+		}
+	}
+	if len(keywords) > 0 {
+		if t != nil {
+			return nil, 0, p.errorf(start, "multiple types in declaration specifiers")
+		}
+		// Type specifiers may occur in any order (e.g. "long unsigned int").
+		spec := strings.Join(keywords, " ")
+		basic, ok := basicTypes[ctype.CanonBasicTypeString(spec)]
+		if !ok {
+			return nil, 0, p.errorf(start, "invalid type specifier %q", spec)
+		}
+		t = basic
+	}
+	if t == nil {
+		return nil, 0, p.errorf(start, "missing type specifier")
+	}
+	if isConst {
+		t = &ctype.ConstType{Typ: t}
+	}
+	return t, cc, nil
+}
+
+// callConv parses a calling convention keyword or attribute at the current
+// position. The boolean result reports whether a keyword or attribute was
+// parsed; the calling convention is zero for attributes not specifying
+// calling conventions.
+func (p *parser) callConv() (ctype.CallingConv, bool, error) {
+	tok := p.peek()
+	if tok.kind != tokenIdent {
+		return 0, false, nil
+	}
+	switch tok.text {
+	case "__usercall", "__userpurge":
+		return 0, false, p.errorf(tok, "%s calling convention not supported in C prototypes; use an LLVM IR stub with a %s annotation", tok.text, tok.text[2:])
+	case "__declspec":
+		p.next()
+		if err := p.skipParens(); err != nil {
+			return 0, false, errors.WithStack(err)
+		}
+		return 0, true, nil
+	case "__attribute__":
+		p.next()
+		cc, err := p.attributes()
+		if err != nil {
+			return 0, false, errors.WithStack(err)
+		}
+		return cc, true, nil
+	}
+	cc, ok := callConvs[tok.text]
+	if !ok {
+		return 0, false, nil
+	}
+	p.next()
+	return cc, true, nil
+}
+
+// attributes parses the attribute list of a GCC attribute specifier (e.g.
+// "((regparm(2)))"), returning the calling convention specified by the
+// attributes, or zero if not specified.
+func (p *parser) attributes() (ctype.CallingConv, error) {
+	if err := p.expect("("); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	if err := p.expect("("); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	var cc ctype.CallingConv
+	for !p.accept(")") {
+		tok := p.next()
+		if tok.kind != tokenIdent {
+			return 0, p.errorf(tok, "invalid attribute %q", tok.text)
+		}
+		switch name := strings.Trim(tok.text, "_"); name {
+		case "cdecl", "stdcall", "fastcall", "thiscall", "vectorcall":
+			cc = callConvs["__"+name]
+		case "ms_abi":
+			cc = ctype.CallConvMSABI
+		case "sysv_abi":
+			cc = ctype.CallConvSysVABI
+		case "regparm":
+			if err := p.expect("("); err != nil {
+				return 0, errors.WithStack(err)
+			}
+			n, err := p.constExpr()
+			if err != nil {
+				return 0, errors.WithStack(err)
+			}
+			switch n {
+			case 0:
+			case 1:
+				cc = ctype.CallConvRegParm1
+			case 2:
+				cc = ctype.CallConvRegParm2
+			case 3:
+				cc = ctype.CallConvRegParm3
+			default:
+				return 0, p.errorf(tok, "invalid number of regparm registers (%d)", n)
+			}
+			if err := p.expect(")"); err != nil {
+				return 0, errors.WithStack(err)
+			}
+		default:
+			// Skip attribute arguments.
+			if p.peek().text == "(" {
+				if err := p.skipParens(); err != nil {
+					return 0, errors.WithStack(err)
+				}
+			}
+		}
+		if !p.accept(",") {
+			if err := p.expect(")"); err != nil {
+				return 0, errors.WithStack(err)
+			}
+			break
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return cc, nil
+}
+
+// tagged parses a structure, union or enum specifier, including its
+// definition if present.
+func (p *parser) tagged() (ctype.Type, error) {
+	keyword := p.next().text
+	var tag string
+	if p.peek().kind == tokenIdent {
+		tag = p.next().text
+	}
+	if len(tag) == 0 && p.peek().text != "{" {
+		return nil, p.errorf(p.peek(), "missing tag or definition of %s type", keyword)
+	}
+	// Named types are shared between declarations, so that types declared
+	// before their definition (e.g. recursive types) are completed by the
+	// definition.
+	key := keyword + " " + tag
+	t := p.tags[key]
+	if t == nil {
+		switch keyword {
+		case "struct":
+			t = &ctype.StructType{Name: tag}
+		case "union":
+			t = &ctype.UnionType{Name: tag}
+		case "enum":
+			t = &ctype.EnumType{Name: tag}
+		}
+		if len(tag) > 0 {
+			p.tags[key] = t
+		}
+	}
+	lbrace := p.peek()
+	if !p.accept("{") {
+		return t, nil
+	}
+	switch t := t.(type) {
+	case *ctype.StructType:
+		if len(t.Fields) > 0 {
+			return nil, p.errorf(lbrace, "redefinition of %v", t)
+		}
+		fields, err := p.fields()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		t.Fields = fields
+	case *ctype.UnionType:
+		if len(t.Fields) > 0 {
+			return nil, p.errorf(lbrace, "redefinition of %v", t)
+		}
+		fields, err := p.fields()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		t.Fields = fields
+	case *ctype.EnumType:
+		if len(t.Enumerators) > 0 {
+			return nil, p.errorf(lbrace, "redefinition of %v", t)
+		}
+		enumerators, err := p.enumerators()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		t.Enumerators = enumerators
+		t.Flags = ctype.IsFlagEnum(enumerators)
+	}
+	return t, nil
+}
+
+// fields parses the field declarations of a structure or union definition,
+// following the opening brace.
+func (p *parser) fields() ([]*ctype.Field, error) {
+	var fields []*ctype.Field
+	for !p.accept("}") {
+		base, cc, err := p.specifiers()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if p.accept(";") {
+			// Anonymous structure or union member; or declaration of tagged
+			// type.
+			if isAnonymous(base) {
+				fields = append(fields, &ctype.Field{Typ: base})
+			}
+			continue
+		}
+		for {
+			field := &ctype.Field{}
+			if p.peek().text != ":" {
+				name, t, err := p.declarator(base, cc)
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
+				field.Name, field.Typ = name, t
+			} else {
+				field.Typ = base
+			}
+			if p.accept(":") {
+				width, err := p.constExpr()
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
+				field.BitField = true
+				field.BitSize = uint64(width)
+			}
+			fields = append(fields, field)
+			if !p.accept(",") {
+				break
+			}
+		}
+		if err := p.expect(";"); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return fields, nil
+}
+
+// isAnonymous reports whether the given type is an anonymous structure or
+// union type.
+func isAnonymous(t ctype.Type) bool {
+	switch t := t.(type) {
+	case *ctype.StructType:
+		return len(t.Name) == 0
+	case *ctype.UnionType:
+		return len(t.Name) == 0
+	default:
+		return false
+	}
+}
+
+// enumerators parses the enumerators of an enum definition, following the
+// opening brace.
+func (p *parser) enumerators() ([]*ctype.Enumerator, error) {
+	var enumerators []*ctype.Enumerator
+	var value int64
+	for !p.accept("}") {
+		tok := p.next()
+		if tok.kind != tokenIdent {
+			return nil, p.errorf(tok, "invalid enumerator name %q", tok.text)
+		}
+		if p.accept("=") {
+			v, err := p.constExpr()
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			value = v
+		}
+		enumerators = append(enumerators, &ctype.Enumerator{Name: tok.text, Value: value})
+		p.enumValues[tok.text] = value
+		value++
+		if !p.accept(",") {
+			if err := p.expect("}"); err != nil {
+				return nil, errors.WithStack(err)
+			}
+			break
+		}
+	}
+	return enumerators, nil
+}
+
+// suffix is an array or function declarator suffix.
+type suffix struct {
+	// Array length; or -1 if unknown.
+	len int64
+	// Function type (with unset return type); or nil if array suffix.
+	funcType *ctype.FuncType
+}
+
+// declarator parses a (possibly abstract) declarator of the given base type,
+// returning the declared identifier (empty if abstract) and type. The calling
+// convention cc (or one specified within the declarator) applies to the
+// outermost function type of the declarator; or to the base type if a
+// function type.
+func (p *parser) declarator(base ctype.Type, cc ctype.CallingConv) (string, ctype.Type, error) {
+	start := p.peek()
+	orig := base
+	// Parse pointers and calling conventions.
+	for {
+		if p.accept("*") {
+			base = &ctype.PointerType{Elem: base}
+			continue
+		}
+		if p.accept("const") {
+			if _, ok := base.(*ctype.PointerType); !ok {
+				return "", nil, p.errorf(start, "invalid const qualifier of declarator")
+			}
+			base = &ctype.ConstType{Typ: base}
+			continue
+		}
+		if ignoredKeywords[p.peek().text] {
+			p.next()
+			continue
+		}
+		c, ok, err := p.callConv()
+		if err != nil {
+			return "", nil, errors.WithStack(err)
+		}
+		if !ok {
+			break
+		}
+		if c != 0 {
+			cc = c
+		}
+	}
+	// Parse identifier or nested declarator.
+	var name string
+	nestedPos := -1
+	switch tok := p.peek(); {
+	case tok.kind == tokenIdent && p.typedefs[tok.text] == nil && !basicTypeKeywords[tok.text]:
+		name = p.next().text
+	case tok.text == "(" && p.isNestedDecl(p.toks[p.pos+1]):
+		nestedPos = p.pos + 1
+		if err := p.skipParens(); err != nil {
+			return "", nil, errors.WithStack(err)
+		}
+	}
+	// Parse array and function suffixes.
+	var suffixes []suffix
+	for {
+		if p.accept("[") {
+			n := int64(-1)
+			if !p.accept("]") {
+				v, err := p.constExpr()
+				if err != nil {
+					return "", nil, errors.WithStack(err)
+				}
+				if err := p.expect("]"); err != nil {
+					return "", nil, errors.WithStack(err)
+				}
+				n = v
+			}
+			suffixes = append(suffixes, suffix{len: n})
+			continue
+		}
+		if p.accept("(") {
+			funcType, err := p.params()
+			if err != nil {
+				return "", nil, errors.WithStack(err)
+			}
+			suffixes = append(suffixes, suffix{funcType: funcType})
+			continue
+		}
+		break
+	}
+	// Construct type from the inside out; the last suffix applies first.
+	t := base
+	var outerFunc *ctype.FuncType
+	for i := len(suffixes) - 1; i >= 0; i-- {
+		s := suffixes[i]
+		if s.funcType == nil {
+			t = &ctype.ArrayType{Elem: t, Len: s.len}
+			continue
+		}
+		s.funcType.RetType = t
+		t = s.funcType
+		outerFunc = s.funcType
+	}
+	if cc != 0 {
+		if outerFunc == nil {
+			funcType, ok := orig.(*ctype.FuncType)
+			if !ok {
+				return "", nil, p.errorf(start, "calling convention %v of non-function type %v", cc, t)
+			}
+			outerFunc = funcType
+		}
+		outerFunc.CallConv = cc
+	}
+	if nestedPos == -1 {
+		return name, t, nil
+	}
+	// Parse nested declarator, with the type constructed so far as base type.
+	end := p.pos
+	p.pos = nestedPos
+	name, t, err := p.declarator(t, 0)
+	if err != nil {
+		return "", nil, errors.WithStack(err)
+	}
+	if err := p.expect(")"); err != nil {
+		return "", nil, errors.WithStack(err)
+	}
+	p.pos = end
+	return name, t, nil
+}
+
+// isNestedDecl reports whether the given token, following an opening
+// parenthesis in a declarator, starts a nested declarator (as opposed to a
+// parameter list).
+func (p *parser) isNestedDecl(tok token) bool {
+	switch tok.text {
+	case "*", "(", "__attribute__", "__declspec":
+		return true
+	}
+	if _, ok := callConvs[tok.text]; ok {
+		return true
+	}
+	return tok.kind == tokenIdent && p.typedefs[tok.text] == nil && !p.isSpecifier(tok.text)
+}
+
+// isSpecifier reports whether the given keyword is part of declaration
+// specifiers.
+func (p *parser) isSpecifier(keyword string) bool {
+	switch keyword {
+	case "const", "struct", "union", "enum":
+		return true
+	}
+	return basicTypeKeywords[keyword] || ignoredKeywords[keyword]
+}
+
+// params parses the parameter list of a function declarator, following the
+// opening parenthesis. An empty parameter list is treated as "(void)".
+func (p *parser) params() (*ctype.FuncType, error) {
+	funcType := &ctype.FuncType{}
+	if p.accept(")") {
+		return funcType, nil
+	}
+	if p.peek().text == "void" && p.toks[p.pos+1].text == ")" {
+		p.pos += 2
+		return funcType, nil
+	}
+	for {
+		if tok := p.peek(); tok.text == "..." {
+			return nil, p.errorf(tok, "variadic functions not supported")
+		}
+		base, cc, err := p.specifiers()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		name, t, err := p.declarator(base, cc)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		funcType.ParamTypes = append(funcType.ParamTypes, t)
+		funcType.ParamNames = append(funcType.ParamNames, name)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return funcType, nil
+}
+
+// binaryPrecs maps from binary operators of constant expressions to their
+// precedence.
+var binaryPrecs = map[string]int{
+	"|":  1,
+	"^":  2,
+	"&":  3,
+	"<<": 4,
+	">>": 4,
+	"+":  5,
+	"-":  5,
+	"*":  6,
+	"/":  6,
+	"%":  6,
+}
+
+// constExpr parses and evaluates an integer constant expression (e.g. "1 <<
+// 4"), which may refer to previously defined enumerators.
+func (p *parser) constExpr() (int64, error) {
+	return p.binaryExpr(1)
+}
+
+// binaryExpr parses and evaluates a binary expression of operators with at
+// least the given precedence.
+func (p *parser) binaryExpr(minPrec int) (int64, error) {
+	x, err := p.unaryExpr()
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	for {
+		op := p.peek()
+		prec, ok := binaryPrecs[op.text]
+		if op.kind != tokenPunct || !ok || prec < minPrec {
+			return x, nil
+		}
+		p.next()
+		y, err := p.binaryExpr(prec + 1)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		switch op.text {
+		case "|":
+			x |= y
+		case "^":
+			x ^= y
+		case "&":
+			x &= y
+		case "<<":
+			x <<= uint64(y)
+		case ">>":
+			x >>= uint64(y)
+		case "+":
+			x += y
+		case "-":
+			x -= y
+		case "*":
+			x *= y
+		case "/", "%":
+			if y == 0 {
+				return 0, p.errorf(op, "division by zero in constant expression")
+			}
+			if op.text == "/" {
+				x /= y
+			} else {
+				x %= y
+			}
+		}
+	}
+}
+
+// unaryExpr parses and evaluates a unary expression.
+func (p *parser) unaryExpr() (int64, error) {
+	tok := p.next()
+	switch {
+	case tok.text == "-" || tok.text == "~" || tok.text == "+":
+		x, err := p.unaryExpr()
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		switch tok.text {
+		case "-":
+			return -x, nil
+		case "~":
+			return ^x, nil
+		}
+		return x, nil
+	case tok.text == "(":
+		x, err := p.constExpr()
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		if err := p.expect(")"); err != nil {
+			return 0, errors.WithStack(err)
+		}
+		return x, nil
+	case tok.kind == tokenNumber:
+		x, err := parseUint(tok.text)
+		if err != nil {
+			return 0, p.errorf(tok, "invalid integer constant %q", tok.text)
+		}
+		return int64(x), nil
+	case tok.kind == tokenIdent:
+		x, ok := p.enumValues[tok.text]
+		if !ok {
+			return 0, p.errorf(tok, "undefined enumerator %q in constant expression", tok.text)
+		}
+		return x, nil
+	}
+	return 0, p.errorf(tok, "invalid constant expression; unexpected %q", tok.text)
+}
+
+// parseUint parses the given C integer constant (e.g. "0x401230UL").
+func parseUint(s string) (uint64, error) {
+	s = strings.TrimRight(s, "uUlL")
+	// Octal integer constants start with a leading zero in C.
+	if len(s) > 1 && s[0] == '0' && isDigit(s[1]) {
+		s = "0o" + s[1:]
+	}
+	x, err := strconv.ParseUint(s, 0, 64)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return x, nil
+}
+
+// skipParens skips the balanced parentheses starting at the current position.
+func (p *parser) skipParens() error {
+	if err := p.expect("("); err != nil {
+		return errors.WithStack(err)
+	}
+	for depth := 1; depth > 0; {
+		tok := p.next()
+		switch {
+		case tok.kind == tokenEOF:
+			return p.errorf(tok, "unbalanced parentheses")
+		case tok.text == "(":
+			depth++
+		case tok.text == ")":
+			depth--
+		}
+	}
+	return nil
+}
+
+// peek returns the token at the current position.
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+// next returns the token at the current position, and advances the position
+// (unless at EOF).
+func (p *parser) next() token {
+	tok := p.toks[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept advances the position and reports true if the current token is the
+// given keyword or punctuator.
+func (p *parser) accept(text string) bool {
+	if tok := p.peek(); tok.kind != tokenEOF && tok.kind != tokenNumber && tok.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// expect advances the position if the current token is the given keyword or
+// punctuator, and returns an error otherwise.
+func (p *parser) expect(text string) error {
+	if tok := p.peek(); !p.accept(text) {
+		return p.errorf(tok, "expected %q, got %q", text, tok.text)
+	}
+	return nil
+}
+
+// errorf returns an error at the position of the given token, as described by
+// the given format specifier and arguments.
+func (p *parser) errorf(tok token, format string, a ...interface{}) error {
+	return errors.Errorf("%s:%d: "+format, append([]interface{}{p.name, tok.line}, a...)...)
+}
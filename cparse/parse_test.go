@@ -0,0 +1,200 @@
+package cparse
+
+import (
+	"testing"
+
+	"github.com/mewmew/genie/ctype"
+)
+
+func TestParseSpecifiers(t *testing.T) {
+	golden := []struct {
+		specs string
+		want  ctype.BasicType
+	}{
+		{specs: "int", want: ctype.BasicTypeInt},
+		{specs: "unsigned", want: ctype.BasicTypeUnsigned},
+		{specs: "int unsigned", want: ctype.BasicTypeUInt},
+		{specs: "unsigned long int", want: ctype.BasicTypeULongInt},
+		{specs: "long unsigned int", want: ctype.BasicTypeULongInt},
+		{specs: "int long unsigned", want: ctype.BasicTypeULongInt},
+		{specs: "long long unsigned", want: ctype.BasicTypeULongLong},
+		{specs: "long unsigned long int", want: ctype.BasicTypeULongLongInt},
+		{specs: "short signed", want: ctype.BasicTypeSShort},
+		{specs: "char unsigned", want: ctype.BasicTypeUChar},
+		{specs: "double long", want: ctype.BasicTypeLongDouble},
+		{specs: "_Complex float", want: ctype.BasicTypeFloatComplex},
+		{specs: "__int128 unsigned", want: ctype.BasicTypeUInt128},
+		{specs: "const long unsigned", want: ctype.BasicTypeULong},
+	}
+	for _, g := range golden {
+		src := g.specs + " f(void) @ 0x401000;"
+		funcs, err := Parse("test.h", []byte(src))
+		if err != nil {
+			t.Errorf("%q: unable to parse: %v", src, err)
+			continue
+		}
+		got := funcs[0].Type.RetType
+		if c, ok := got.(*ctype.ConstType); ok {
+			got = c.Typ
+		}
+		if got != g.want {
+			t.Errorf("%q: return type mismatch; expected %v, got %v", src, g.want, got)
+		}
+	}
+}
+
+func TestParseInvalidSpecifiers(t *testing.T) {
+	golden := []string{
+		"signed unsigned f(void) @ 0x401000;",
+		"long short f(void) @ 0x401000;",
+		"int char f(void) @ 0x401000;",
+		"long long long f(void) @ 0x401000;",
+	}
+	for _, src := range golden {
+		if _, err := Parse("test.h", []byte(src)); err == nil {
+			t.Errorf("%q: expected error, got nil", src)
+		}
+	}
+}
+
+func TestParseDeclarators(t *testing.T) {
+	golden := []struct {
+		src  string
+		want string
+	}{
+		{
+			src:  "int f(int x, char *s) @ 0x401000;",
+			want: "int f(int x, char *s)",
+		},
+		{
+			src:  "void f(const char *s, int a[3]) @ 0x401000;",
+			want: "void f(const char *s, int a[3])",
+		},
+		{
+			src:  "int *f(int **pp) @ 0x401000;",
+			want: "int *f(int **pp)",
+		},
+		{
+			src:  "void f(int (*cb)(int, char *)) @ 0x401000;",
+			want: "void f(int (*cb)(int, char *))",
+		},
+		{
+			src:  "int __stdcall f(int x) @ 0x401000;",
+			want: "int __stdcall f(int x)",
+		},
+		{
+			src:  "typedef struct { int x; int y; } point; int f(point *p) @ 0x401000;",
+			want: "int f(point *p)",
+		},
+	}
+	for _, g := range golden {
+		funcs, err := Parse("test.h", []byte(g.src))
+		if err != nil {
+			t.Errorf("%q: unable to parse: %v", g.src, err)
+			continue
+		}
+		fn := funcs[0]
+		if got := ctype.Decl(fn.Type, fn.Name); got != g.want {
+			t.Errorf("%q: declaration mismatch; expected %q, got %q", g.src, g.want, got)
+		}
+	}
+}
+
+func TestParseAnnotations(t *testing.T) {
+	golden := []struct {
+		src  string
+		want Func
+	}{
+		{
+			src:  "int f(void) @ 0x401230;",
+			want: Func{Name: "f", Addr: 0x401230},
+		},
+		{
+			src:  "int f(void) @ 4198960;",
+			want: Func{Name: "f", Addr: 0x401230},
+		},
+		{
+			src:  `int f(void) @ "bar";`,
+			want: Func{Name: "f", Sym: "bar"},
+		},
+		{
+			src:  `int f(void) @ "#3";`,
+			want: Func{Name: "f", Sym: "#3"},
+		},
+		{
+			src:  `int f(const char *s) @ iat "msvcrt.dll!puts";`,
+			want: Func{Name: "f", Import: "msvcrt.dll!puts"},
+		},
+	}
+	for _, g := range golden {
+		funcs, err := Parse("test.h", []byte(g.src))
+		if err != nil {
+			t.Errorf("%q: unable to parse: %v", g.src, err)
+			continue
+		}
+		if len(funcs) != 1 {
+			t.Errorf("%q: expected 1 function, got %d", g.src, len(funcs))
+			continue
+		}
+		got := funcs[0]
+		if got.Name != g.want.Name || got.Addr != g.want.Addr || got.Sym != g.want.Sym || got.Import != g.want.Import {
+			t.Errorf("%q: annotation mismatch; expected {%q 0x%X %q %q}, got {%q 0x%X %q %q}", g.src, g.want.Name, g.want.Addr, g.want.Sym, g.want.Import, got.Name, got.Addr, got.Sym, got.Import)
+		}
+	}
+}
+
+func TestParseInvalidAnnotations(t *testing.T) {
+	golden := []string{
+		"int f(void);",
+		"int f(void) @ foo;",
+		"int x @ 0x401000;",
+		`int f(void) @ "";`,
+		`int f(void) @ "bar`,
+		"int f(void) @ iat 0x401000;",
+		`int f(void) @ iat "";`,
+	}
+	for _, src := range golden {
+		if _, err := Parse("test.h", []byte(src)); err == nil {
+			t.Errorf("%q: expected error, got nil", src)
+		}
+	}
+}
+
+func TestParseEnums(t *testing.T) {
+	golden := []struct {
+		src   string
+		flags bool
+	}{
+		{
+			src:   "enum color { RED, GREEN, BLUE }; void f(enum color c) @ 0x401000;",
+			flags: false,
+		},
+		{
+			src:   "enum mode { READ = 1, WRITE = 2, EXEC = 4 }; void f(enum mode m) @ 0x401000;",
+			flags: true,
+		},
+		{
+			src:   "typedef enum { A = 0x10, B = 0x20, AB = 0x30 } ab_t; void f(ab_t m) @ 0x401000;",
+			flags: true,
+		},
+	}
+	for _, g := range golden {
+		funcs, err := Parse("test.h", []byte(g.src))
+		if err != nil {
+			t.Errorf("%q: unable to parse: %v", g.src, err)
+			continue
+		}
+		typ := funcs[0].Type.ParamTypes[0]
+		if def, ok := typ.(*ctype.Typedef); ok {
+			typ = def.Typ
+		}
+		e, ok := typ.(*ctype.EnumType)
+		if !ok {
+			t.Errorf("%q: type mismatch; expected *ctype.EnumType, got %T", g.src, typ)
+			continue
+		}
+		if e.Flags != g.flags {
+			t.Errorf("%q: flags mismatch; expected %v, got %v", g.src, g.flags, e.Flags)
+		}
+	}
+}
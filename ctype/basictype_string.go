@@ -38,11 +38,21 @@ func _() {
 	_ = x[BasicTypeFloat-28]
 	_ = x[BasicTypeDouble-29]
 	_ = x[BasicTypeLongDouble-30]
+	_ = x[BasicTypeBool-31]
+	_ = x[BasicTypeFloatComplex-32]
+	_ = x[BasicTypeDoubleComplex-33]
+	_ = x[BasicTypeLongDoubleComplex-34]
+	_ = x[BasicTypeWChar-35]
+	_ = x[BasicTypeChar16-36]
+	_ = x[BasicTypeChar32-37]
+	_ = x[BasicTypeInt128-38]
+	_ = x[BasicTypeSInt128-39]
+	_ = x[BasicTypeUInt128-40]
 }
 
-const _BasicType_name = "voidcharsigned charunsigned charshortshort intsigned shortsigned short intunsigned shortunsigned short intintsignedsigned intunsignedunsigned intlonglong intsigned longsigned long intunsigned longunsigned long intlong longlong long intsigned long longsigned long long intunsigned long longunsigned long long intfloatdoublelong double"
+const _BasicType_name = "voidcharsigned charunsigned charshortshort intsigned shortsigned short intunsigned shortunsigned short intintsignedsigned intunsignedunsigned intlonglong intsigned longsigned long intunsigned longunsigned long intlong longlong long intsigned long longsigned long long intunsigned long longunsigned long long intfloatdoublelong double_Boolfloat _Complexdouble _Complexlong double _Complexwchar_tchar16_tchar32_t__int128signed __int128unsigned __int128"
 
-var _BasicType_index = [...]uint16{0, 4, 8, 19, 32, 37, 46, 58, 74, 88, 106, 109, 115, 125, 133, 145, 149, 157, 168, 183, 196, 213, 222, 235, 251, 271, 289, 311, 316, 322, 333}
+var _BasicType_index = [...]uint16{0, 4, 8, 19, 32, 37, 46, 58, 74, 88, 106, 109, 115, 125, 133, 145, 149, 157, 168, 183, 196, 213, 222, 235, 251, 271, 289, 311, 316, 322, 333, 338, 352, 367, 387, 394, 402, 410, 418, 433, 450}
 
 func (i BasicType) String() string {
 	i -= 1
@@ -10,11 +10,19 @@ func _() {
 	var x [1]struct{}
 	_ = x[CallConvFastCall-1]
 	_ = x[CallConvStdCall-2]
+	_ = x[CallConvCDecl-3]
+	_ = x[CallConvThisCall-4]
+	_ = x[CallConvVectorCall-5]
+	_ = x[CallConvRegParm1-6]
+	_ = x[CallConvRegParm2-7]
+	_ = x[CallConvRegParm3-8]
+	_ = x[CallConvMSABI-9]
+	_ = x[CallConvSysVABI-10]
 }
 
-const _CallingConv_name = "__fastcall__stdcall"
+const _CallingConv_name = "__fastcall__stdcall__cdecl__thiscall__vectorcall__attribute__((regparm(1)))__attribute__((regparm(2)))__attribute__((regparm(3)))__attribute__((ms_abi))__attribute__((sysv_abi))"
 
-var _CallingConv_index = [...]uint8{0, 10, 19}
+var _CallingConv_index = [...]uint8{0, 10, 19, 26, 36, 48, 75, 102, 129, 152, 177}
 
 func (i CallingConv) String() string {
 	i -= 1
@@ -0,0 +1,99 @@
+package ctype
+
+import "fmt"
+
+// Decl returns the C syntax representation of the declaration of the given
+// identifier of the specified type (e.g. "char (*x)[32]"). An empty identifier
+// results in an abstract declarator (e.g. "char (*)[32]"), as used by type
+// names in casts and parameter lists.
+//
+// Declarators are constructed from the inside out (following the spiral rule),
+// parenthesizing pointer declarators which precede array and function
+// declarators. Anonymous structure, union and enum types are defined in place.
+func Decl(t Type, ident string) string {
+	decl := ident
+	// Current declarator is a pointer declarator.
+	ptr := false
+	for {
+		switch tt := t.(type) {
+		case *PointerType:
+			decl = "*" + decl
+			ptr = true
+			t = tt.Elem
+		case *ConstType:
+			// Constant pointers are qualified after the asterisk (e.g.
+			// "char *const p"); qualifiers of array types apply to their
+			// elements (e.g. "const int a[3]"); other types are qualified
+			// before the type specifier (e.g. "const char c").
+			switch typ := tt.Typ.(type) {
+			case *PointerType:
+				decl = joinDecl("const", decl)
+				t = typ
+			case *ArrayType:
+				t = &ArrayType{Elem: &ConstType{Typ: typ.Elem}, Len: typ.Len}
+			default:
+				return joinDecl("const "+typeSpec(typ), decl)
+			}
+		case *ArrayType:
+			if ptr {
+				decl = "(" + decl + ")"
+				ptr = false
+			}
+			if tt.Len < 0 {
+				decl += "[]"
+			} else {
+				decl += fmt.Sprintf("[%d]", tt.Len)
+			}
+			t = tt.Elem
+		case *FuncType:
+			if tt.CallConv != 0 {
+				decl = joinDecl(tt.CallConv.String(), decl)
+			}
+			if ptr {
+				decl = "(" + decl + ")"
+				ptr = false
+			}
+			decl += tt.paramsString()
+			t = tt.RetType
+		default:
+			return joinDecl(typeSpec(t), decl)
+		}
+	}
+}
+
+// typeSpec returns the C syntax representation of the type specifier of the
+// given type. Anonymous structure, union and enum types are specified by their
+// definition (e.g. "struct {\n\tint x;\n}"), as they cannot be referred to by
+// name.
+func typeSpec(t Type) string {
+	switch t := t.(type) {
+	case *StructType:
+		if len(t.Name) == 0 {
+			return fieldsBody(t.String(), t.Fields)
+		}
+	case *UnionType:
+		if len(t.Name) == 0 {
+			return fieldsBody(t.String(), t.Fields)
+		}
+	case *EnumType:
+		if len(t.Name) == 0 {
+			return t.body()
+		}
+	}
+	return t.String()
+}
+
+// joinDecl returns the concatenation of the given type specifier (or
+// qualifier) and declarator, separated by space if the declarator is non-empty.
+func joinDecl(spec, decl string) string {
+	if len(decl) == 0 {
+		return spec
+	}
+	return spec + " " + decl
+}
+
+// tagString returns the C syntax representation of the given tagged type (e.g.
+// "struct foo").
+func tagString(keyword, tag string) string {
+	return joinDecl(keyword, tag)
+}
@@ -0,0 +1,40 @@
+package ctype
+
+import "testing"
+
+func TestDecl(t *testing.T) {
+	constInt := &ConstType{Typ: BasicTypeInt}
+	charPtr := &PointerType{Elem: BasicTypeChar}
+	golden := []struct {
+		t     Type
+		ident string
+		want  string
+	}{
+		{t: BasicTypeInt, ident: "x", want: "int x"},
+		{t: constInt, ident: "x", want: "const int x"},
+		{t: &PointerType{Elem: &ConstType{Typ: BasicTypeChar}}, ident: "s", want: "const char *s"},
+		{t: &ConstType{Typ: charPtr}, ident: "p", want: "char *const p"},
+		{t: &ArrayType{Elem: BasicTypeInt, Len: 3}, ident: "a", want: "int a[3]"},
+		{t: &PointerType{Elem: &ArrayType{Elem: BasicTypeChar, Len: 32}}, ident: "x", want: "char (*x)[32]"},
+		{t: &PointerType{Elem: &ArrayType{Elem: BasicTypeChar, Len: 32}}, ident: "", want: "char (*)[32]"},
+		// Constant arrays.
+		{t: &ConstType{Typ: &ArrayType{Elem: BasicTypeInt, Len: 3}}, ident: "a", want: "const int a[3]"},
+		{t: &ConstType{Typ: &ArrayType{Elem: BasicTypeInt, Len: 3}}, ident: "", want: "const int [3]"},
+		{t: &ConstType{Typ: &ArrayType{Elem: BasicTypeInt, Len: -1}}, ident: "a", want: "const int a[]"},
+		{t: &ConstType{Typ: &ArrayType{Elem: &ArrayType{Elem: BasicTypeInt, Len: 3}, Len: 2}}, ident: "m", want: "const int m[2][3]"},
+		{t: &ConstType{Typ: &ArrayType{Elem: charPtr, Len: 4}}, ident: "v", want: "char *const v[4]"},
+		{t: &PointerType{Elem: &ConstType{Typ: &ArrayType{Elem: BasicTypeInt, Len: 3}}}, ident: "p", want: "const int (*p)[3]"},
+		{t: &ConstType{Typ: &ArrayType{Elem: &Typedef{Name: "point"}, Len: 3}}, ident: "a", want: "const point a[3]"},
+		{t: &FuncType{RetType: BasicTypeVoid, ParamTypes: []Type{&ConstType{Typ: &ArrayType{Elem: BasicTypeInt, Len: 3}}}, ParamNames: []string{"a"}}, ident: "f", want: "void f(const int a[3])"},
+		// Anonymous types are defined in place.
+		{t: &StructType{Fields: []*Field{{Name: "x", Typ: BasicTypeInt}}}, ident: "s", want: "struct {\n\tint x;\n} s"},
+		{t: &PointerType{Elem: &UnionType{Fields: []*Field{{Name: "i", Typ: BasicTypeInt}}}}, ident: "p", want: "union {\n\tint i;\n} *p"},
+		{t: &EnumType{Enumerators: []*Enumerator{{Name: "A", Value: 1}}}, ident: "e", want: "enum {\n\tA = 1,\n} e"},
+		{t: &StructType{Name: "point", Fields: []*Field{{Name: "x", Typ: BasicTypeInt}}}, ident: "p", want: "struct point p"},
+	}
+	for _, g := range golden {
+		if got := Decl(g.t, g.ident); got != g.want {
+			t.Errorf("declaration mismatch of %q; expected %q, got %q", g.ident, g.want, got)
+		}
+	}
+}
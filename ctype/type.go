@@ -4,13 +4,21 @@ package ctype
 import (
 	"bytes"
 	"fmt"
+	"strings"
 )
 
 // === [ Type ] ================================================================
 
 // Type is a C type.
 type Type interface {
+	// String returns the C syntax representation of the type (i.e. the type
+	// name, as used in declarations).
 	fmt.Stringer
+	// CString returns the C syntax representation of the definition of the
+	// type. Types without definitions of their own (basic, derived and
+	// function types) are represented by their type names; use Decl for the
+	// declaration of an identifier of the type.
+	CString() string
 }
 
 // --- [ Basic type ] ----------------------------------------------------------
@@ -19,13 +27,44 @@ type Type interface {
 type BasicType uint
 
 // CString returns the C syntax representation of the definition of the type.
+// Basic types are predefined by the language, and are thus represented by
+// their type specifiers.
 func (t BasicType) CString() string {
-	switch t {
-	case BasicTypeChar:
-		return fmt.Sprintf("typedef char int8_t;")
-	default:
-		panic(fmt.Errorf("support for basic type %v (%s) not yet implemented", uint(t), t))
+	return t.String()
+}
+
+// CanonBasicTypeString returns the canonical basic type string.
+//
+// The canonical order of type specifiers is sign, size, base type and complex,
+// as used by the string representation of BasicType (e.g. "unsigned long
+// long int" and "long double _Complex").
+func CanonBasicTypeString(name string) string {
+	// "the type specifiers may occur in any order, possibly intermixed with the
+	// other declaration specifiers."
+	//
+	// ref: https://stackoverflow.com/a/45159300
+	var sign, size, base, complex []string
+	for _, spec := range strings.Fields(name) {
+		switch spec {
+		case "signed", "unsigned":
+			sign = append(sign, spec)
+		case "short", "long":
+			size = append(size, spec)
+		case "_Complex", "complex":
+			complex = append(complex, "_Complex")
+		case "bool":
+			// C++ bool.
+			base = append(base, "_Bool")
+		default:
+			base = append(base, spec)
+		}
 	}
+	var specs []string
+	specs = append(specs, sign...)
+	specs = append(specs, size...)
+	specs = append(specs, base...)
+	specs = append(specs, complex...)
+	return strings.Join(specs, " ")
 }
 
 //go:generate stringer -linecomment -type BasicType
@@ -79,6 +118,21 @@ const (
 	BasicTypeDouble // double
 	// IEEE 754 quadruple-precision floating-point format (128 bits)
 	BasicTypeLongDouble // long double
+	// [0, 1]
+	BasicTypeBool // _Bool
+	// complex floating-point types
+	BasicTypeFloatComplex      // float _Complex
+	BasicTypeDoubleComplex     // double _Complex
+	BasicTypeLongDoubleComplex // long double _Complex
+	// wide character types
+	BasicTypeWChar  // wchar_t
+	BasicTypeChar16 // char16_t
+	BasicTypeChar32 // char32_t
+	// [-2^127, +2^127 - 1] (GCC and Clang extension)
+	BasicTypeInt128  // __int128
+	BasicTypeSInt128 // signed __int128
+	// [0, 2^128 - 1] (GCC and Clang extension)
+	BasicTypeUInt128 // unsigned __int128
 )
 
 // --- [ Constant type ] -------------------------------------------------------
@@ -91,7 +145,12 @@ type ConstType struct {
 
 // String returns the C syntax representation of the type.
 func (t *ConstType) String() string {
-	return fmt.Sprintf("const %v", t.Typ.String())
+	return Decl(t, "")
+}
+
+// CString returns the type name of the constant type (e.g. "const int").
+func (t *ConstType) CString() string {
+	return t.String()
 }
 
 // --- [ Pointer type ] --------------------------------------------------------
@@ -104,8 +163,33 @@ type PointerType struct {
 
 // String returns the C syntax representation of the type.
 func (t *PointerType) String() string {
-	// TODO: use "Var" hack to handle spiral rule?
-	return fmt.Sprintf("%v *", t.Elem.String())
+	return Decl(t, "")
+}
+
+// CString returns the type name of the pointer type (e.g. "char *").
+func (t *PointerType) CString() string {
+	return t.String()
+}
+
+// --- [ Array type ] ----------------------------------------------------------
+
+// ArrayType is a C array type. Multi-dimensional arrays are represented as
+// arrays of arrays.
+type ArrayType struct {
+	// Element type.
+	Elem Type
+	// Number of elements; or -1 if unknown (e.g. flexible array member).
+	Len int64
+}
+
+// String returns the C syntax representation of the type.
+func (t *ArrayType) String() string {
+	return Decl(t, "")
+}
+
+// CString returns the type name of the array type (e.g. "int [3]" or "int []").
+func (t *ArrayType) CString() string {
+	return t.String()
 }
 
 // --- [ Enum type ] --------------------------------------------------------
@@ -114,12 +198,88 @@ func (t *PointerType) String() string {
 type EnumType struct {
 	// Enum name (tag).
 	Name string
-	// TODO: add Enum fields.
+	// Enumerators, in order of declaration.
+	Enumerators []*Enumerator
+	// Enum values are sets of bit flags.
+	Flags bool
 }
 
 // String returns the C syntax representation of the type.
 func (t *EnumType) String() string {
-	return t.Name
+	return tagString("enum", t.Name)
+}
+
+// CString returns the C syntax representation of the definition of the type.
+// Enum types without enumerators are forward declared.
+func (t *EnumType) CString() string {
+	if len(t.Enumerators) == 0 {
+		return t.String() + ";"
+	}
+	return t.body() + ";"
+}
+
+// body returns the C syntax representation of the enum specifier of the type,
+// including its enumerators.
+func (t *EnumType) body() string {
+	buf := &bytes.Buffer{}
+	buf.WriteString(joinDecl(t.String(), "{\n"))
+	for _, enumerator := range t.Enumerators {
+		fmt.Fprintf(buf, "\t%s = %d,\n", enumerator.Name, enumerator.Value)
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
+// Enumerator is an enumeration constant of a C enumerate type.
+type Enumerator struct {
+	// Enumerator name.
+	Name string
+	// Enumerator value.
+	Value int64
+}
+
+// IsFlagEnum reports whether the given enumerators are likely to denote a set
+// of bit flags. This is the case if there are at least two single bit
+// enumerators, every other non-zero value is a combination of single bit
+// enumerators, and the values do not form a contiguous range (e.g. 0..N or
+// 1..N) as used by ordinary sequential enums.
+func IsFlagEnum(enumerators []*Enumerator) bool {
+	var bits uint64
+	nbits := 0
+	values := make(map[uint64]bool)
+	for _, enumerator := range enumerators {
+		v := uint64(enumerator.Value)
+		values[v] = true
+		if v != 0 && v&(v-1) == 0 && bits&v == 0 {
+			bits |= v
+			nbits++
+		}
+	}
+	if nbits < 2 || isContiguous(values) {
+		return false
+	}
+	for v := range values {
+		if v&^bits != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// isContiguous reports whether the given set of non-negative values forms a
+// contiguous range starting at 0 or 1.
+func isContiguous(values map[uint64]bool) bool {
+	start := uint64(1)
+	if values[0] {
+		start = 0
+	}
+	n := uint64(len(values))
+	for v := range values {
+		if v < start || v >= start+n {
+			return false
+		}
+	}
+	return true
 }
 
 // --- [ Struct type ] --------------------------------------------------------
@@ -128,12 +288,80 @@ func (t *EnumType) String() string {
 type StructType struct {
 	// Struct name (tag).
 	Name string
-	// TODO: add struct fields.
+	// Struct fields, in order of declaration.
+	Fields []*Field
 }
 
 // String returns the C syntax representation of the type.
 func (t *StructType) String() string {
-	return t.Name
+	return tagString("struct", t.Name)
+}
+
+// CString returns the C syntax representation of the definition of the type.
+// Structure types without fields are forward declared.
+func (t *StructType) CString() string {
+	if len(t.Fields) == 0 {
+		return t.String() + ";"
+	}
+	return fieldsBody(t.String(), t.Fields) + ";"
+}
+
+// --- [ Union type ] ----------------------------------------------------------
+
+// UnionType is a C union type.
+type UnionType struct {
+	// Union name (tag).
+	Name string
+	// Union fields, in order of declaration.
+	Fields []*Field
+}
+
+// String returns the C syntax representation of the type.
+func (t *UnionType) String() string {
+	return tagString("union", t.Name)
+}
+
+// CString returns the C syntax representation of the definition of the type.
+// Union types without fields are forward declared.
+func (t *UnionType) CString() string {
+	if len(t.Fields) == 0 {
+		return t.String() + ";"
+	}
+	return fieldsBody(t.String(), t.Fields) + ";"
+}
+
+// --- [ Field ] ---------------------------------------------------------------
+
+// Field is a field of a C structure or union type.
+type Field struct {
+	// Field name; empty if anonymous.
+	Name string
+	// Field type.
+	Typ Type
+	// Offset in number of bits from the start of the enclosing type.
+	BitOffset uint64
+	// Size in number of bits.
+	BitSize uint64
+	// Field is a bit field.
+	BitField bool
+}
+
+// fieldsBody returns the C syntax representation of the structure or union
+// specifier with the given type (e.g. "struct foo") and fields.
+func fieldsBody(spec string, fields []*Field) string {
+	buf := &bytes.Buffer{}
+	buf.WriteString(joinDecl(spec, "{\n"))
+	for _, field := range fields {
+		decl := Decl(field.Typ, field.Name)
+		if field.BitField {
+			decl += fmt.Sprintf(" : %d", field.BitSize)
+		}
+		// Indent definitions of anonymous structure, union and enum types.
+		decl = strings.Replace(decl, "\n", "\n\t", -1)
+		fmt.Fprintf(buf, "\t%s;\n", decl)
+	}
+	buf.WriteString("}")
+	return buf.String()
 }
 
 // --- [ Type definition ] -----------------------------------------------------
@@ -151,10 +379,9 @@ func (t *Typedef) String() string {
 	return t.Name
 }
 
-// CString returns the C syntax representation of definition of the type.
+// CString returns the C syntax representation of the definition of the type.
 func (t *Typedef) CString() string {
-	// TODO: use "Var" hack to handle spiral rule?
-	return fmt.Sprintf("typedef %s %s;", t.Name, t.Typ)
+	return fmt.Sprintf("typedef %s;", Decl(t.Typ, t.Name))
 }
 
 // --- [ Function type ] -------------------------------------------------------
@@ -163,25 +390,42 @@ func (t *Typedef) CString() string {
 type FuncType struct {
 	// Return type.
 	RetType Type
-	// Callee name (used for printing type-ident pairs).
-	Callee string
-	// Calling convention.
+	// Calling convention; or zero if not specified.
 	CallConv CallingConv
 	// Parameter types.
 	ParamTypes []Type
+	// (optional) Parameter names; used when printing function declarations.
+	ParamNames []string
 }
 
 // String returns the C syntax representation of the type.
 func (t *FuncType) String() string {
+	return Decl(t, "")
+}
+
+// CString returns the type name of the function type (e.g. "int (int)"), as
+// function types are defined by type definitions (see Typedef).
+func (t *FuncType) CString() string {
+	return t.String()
+}
+
+// paramsString returns the C syntax representation of the parameter list of
+// the function type.
+func (t *FuncType) paramsString() string {
+	if len(t.ParamTypes) == 0 {
+		return "(void)"
+	}
 	buf := &bytes.Buffer{}
-	buf.WriteString(t.RetType.String())
-	fmt.Fprintf(buf, " (*%s)", t.Callee)
 	buf.WriteString("(")
 	for i, param := range t.ParamTypes {
 		if i != 0 {
 			buf.WriteString(", ")
 		}
-		buf.WriteString(param.String())
+		var name string
+		if i < len(t.ParamNames) {
+			name = t.ParamNames[i]
+		}
+		buf.WriteString(Decl(param, name))
 	}
 	buf.WriteString(")")
 	return buf.String()
@@ -194,6 +438,18 @@ type CallingConv uint8
 
 // Calling conventions.
 const (
-	CallConvFastCall CallingConv = iota + 1 // __fastcall
-	CallConvStdCall                         // __stdcall
+	CallConvFastCall   CallingConv = iota + 1 // __fastcall
+	CallConvStdCall                           // __stdcall
+	CallConvCDecl                             // __cdecl
+	CallConvThisCall                          // __thiscall
+	CallConvVectorCall                        // __vectorcall
+	// cdecl with the first 1 to 3 integer parameters passed in EAX, EDX and
+	// ECX (i386).
+	CallConvRegParm1 // __attribute__((regparm(1)))
+	CallConvRegParm2 // __attribute__((regparm(2)))
+	CallConvRegParm3 // __attribute__((regparm(3)))
+	// Microsoft x64 calling convention.
+	CallConvMSABI // __attribute__((ms_abi))
+	// System V AMD64 calling convention.
+	CallConvSysVABI // __attribute__((sysv_abi))
 )
@@ -0,0 +1,183 @@
+package ctype
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestArrayTypeString(t *testing.T) {
+	golden := []struct {
+		typ  *ArrayType
+		want string
+	}{
+		{typ: &ArrayType{Elem: BasicTypeInt, Len: 3}, want: "int [3]"},
+		{typ: &ArrayType{Elem: BasicTypeChar, Len: -1}, want: "char []"},
+		// int [2][3]
+		{typ: &ArrayType{Elem: &ArrayType{Elem: BasicTypeInt, Len: 3}, Len: 2}, want: "int [2][3]"},
+	}
+	for _, g := range golden {
+		if got := g.typ.String(); got != g.want {
+			t.Errorf("string mismatch; expected %q, got %q", g.want, got)
+		}
+	}
+}
+
+func TestBasicTypeString(t *testing.T) {
+	for typ := BasicTypeVoid; typ <= BasicTypeUInt128; typ++ {
+		if got := typ.CString(); got != typ.String() {
+			t.Errorf("CString mismatch of %v; expected %q, got %q", uint(typ), typ.String(), got)
+		}
+		// The C syntax representation of basic types are in canonical order.
+		if strings.HasPrefix(typ.String(), "BasicType(") {
+			t.Errorf("missing C syntax representation of basic type %v", uint(typ))
+		}
+	}
+}
+
+func TestDefinitionCString(t *testing.T) {
+	golden := []struct {
+		t    Type
+		want string
+	}{
+		{
+			t: &StructType{
+				Name: "flags",
+				Fields: []*Field{
+					{Name: "a", Typ: BasicTypeUInt, BitSize: 3, BitField: true},
+					{Name: "p", Typ: &PointerType{Elem: BasicTypeChar}, BitSize: 64},
+					{Name: "in", Typ: &StructType{Fields: []*Field{{Name: "x", Typ: BasicTypeInt}}}},
+				},
+			},
+			want: "struct flags {\n\tunsigned int a : 3;\n\tchar *p;\n\tstruct {\n\t\tint x;\n\t} in;\n};",
+		},
+		{
+			t:    &StructType{Name: "opaque"},
+			want: "struct opaque;",
+		},
+		{
+			t:    &UnionType{Name: "val", Fields: []*Field{{Name: "i", Typ: BasicTypeInt}, {Name: "f", Typ: BasicTypeFloat}}},
+			want: "union val {\n\tint i;\n\tfloat f;\n};",
+		},
+		{
+			t:    &UnionType{Name: "opaque"},
+			want: "union opaque;",
+		},
+		{
+			t:    &EnumType{Name: "color", Enumerators: []*Enumerator{{Name: "RED", Value: 0}, {Name: "BLUE", Value: -1}}},
+			want: "enum color {\n\tRED = 0,\n\tBLUE = -1,\n};",
+		},
+	}
+	for _, g := range golden {
+		if got := g.t.CString(); got != g.want {
+			t.Errorf("definition mismatch of %v; expected %q, got %q", g.t, g.want, got)
+		}
+	}
+}
+
+func TestEnumTypeCString(t *testing.T) {
+	golden := []struct {
+		t    *EnumType
+		want string
+	}{
+		// Forward declared enum.
+		{
+			t:    &EnumType{Name: "opaque"},
+			want: "enum opaque;",
+		},
+		{
+			t: &EnumType{
+				Name: "color",
+				Enumerators: []*Enumerator{
+					{Name: "RED", Value: 0},
+					{Name: "GREEN", Value: 1},
+				},
+			},
+			want: "enum color {\n\tRED = 0,\n\tGREEN = 1,\n};",
+		},
+	}
+	for _, g := range golden {
+		if got := g.t.CString(); got != g.want {
+			t.Errorf("%v: C definition mismatch; expected %q, got %q", g.t, g.want, got)
+		}
+	}
+}
+
+func TestTypeCString(t *testing.T) {
+	golden := []struct {
+		t    Type
+		want string
+	}{
+		{t: BasicTypeULongInt, want: "unsigned long int"},
+		{t: &ConstType{Typ: BasicTypeInt}, want: "const int"},
+		{t: &PointerType{Elem: BasicTypeChar}, want: "char *"},
+		{t: &ArrayType{Elem: BasicTypeInt, Len: 3}, want: "int [3]"},
+		{t: &ArrayType{Elem: BasicTypeInt, Len: -1}, want: "int []"},
+		{t: &FuncType{RetType: BasicTypeInt, ParamTypes: []Type{BasicTypeInt}}, want: "int (int)"},
+		{t: &Typedef{Name: "size_t", Typ: BasicTypeULongInt}, want: "typedef unsigned long int size_t;"},
+	}
+	for _, g := range golden {
+		if got := g.t.CString(); got != g.want {
+			t.Errorf("C representation mismatch of %v; expected %q, got %q", g.t, g.want, got)
+		}
+	}
+}
+
+func TestCanonBasicTypeString(t *testing.T) {
+	golden := []struct {
+		name string
+		want string
+	}{
+		{name: "int", want: "int"},
+		{name: "long unsigned int", want: "unsigned long int"},
+		{name: "short unsigned int", want: "unsigned short int"},
+		{name: "long long unsigned int", want: "unsigned long long int"},
+		{name: "int long signed", want: "signed long int"},
+		{name: "complex double long", want: "long double _Complex"},
+		{name: "unsigned __int128", want: "unsigned __int128"},
+		{name: "bool", want: "_Bool"},
+	}
+	for _, g := range golden {
+		if got := CanonBasicTypeString(g.name); got != g.want {
+			t.Errorf("%q: canonical name mismatch; expected %q, got %q", g.name, g.want, got)
+		}
+	}
+}
+
+func TestIsFlagEnum(t *testing.T) {
+	golden := []struct {
+		values []int64
+		want   bool
+	}{
+		// Sequential enums.
+		{values: []int64{0, 1}, want: false},
+		{values: []int64{0, 1, 2}, want: false},
+		{values: []int64{0, 1, 2, 3, 4}, want: false},
+		{values: []int64{1, 2, 3, 4}, want: false},
+		{values: []int64{4, 3, 2, 1, 0}, want: false},
+		// Bit masks.
+		{values: []int64{1, 2, 4}, want: true},
+		{values: []int64{0, 1, 2, 4, 8}, want: true},
+		{values: []int64{0x1, 0x10, 0x100}, want: true},
+		// Masks with combinations of named bits.
+		{values: []int64{1, 2, 4, 6}, want: true},
+		{values: []int64{0, 1, 2, 4, 7}, want: true},
+		{values: []int64{1, 4, 5}, want: true},
+		// Mixed enums.
+		{values: []int64{1, 2, 4, 5, 9}, want: false},
+		{values: []int64{0, 1, 2, 4, 10}, want: false},
+		{values: []int64{1, 4, -1}, want: false},
+		// Too few single bits.
+		{values: []int64{0, 8}, want: false},
+		{values: []int64{8}, want: false},
+		{values: nil, want: false},
+	}
+	for _, g := range golden {
+		var enumerators []*Enumerator
+		for _, v := range g.values {
+			enumerators = append(enumerators, &Enumerator{Name: "X", Value: v})
+		}
+		if got := IsFlagEnum(enumerators); got != g.want {
+			t.Errorf("IsFlagEnum(%v): expected %v, got %v", g.values, g.want, got)
+		}
+	}
+}
@@ -6,4 +6,5 @@ require (
 	github.com/llir/llvm v0.3.0
 	github.com/mewmew/pe v0.0.0-20190308153105-a3ed7aa3c65a
 	github.com/pkg/errors v0.8.1
+	golang.org/x/arch v0.3.0
 )
@@ -0,0 +1,53 @@
+package mdutil
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/llir/llvm/ir/metadata"
+)
+
+// UnsupportedError is an error reporting LLVM IR metadata (or other function
+// properties) not yet supported by the translation to C.
+type UnsupportedError struct {
+	// Function name; empty if not known.
+	FuncName string
+	// C variable name; empty if not known.
+	VarName string
+	// Metadata ID of the unsupported metadata node; -1 if not present.
+	NodeID int64
+	// Description of the unsupported feature.
+	Msg string
+}
+
+// unsupported returns a new error reporting the unsupported metadata node,
+// described by the given format specifier and arguments.
+func unsupported(node interface{}, format string, a ...interface{}) *UnsupportedError {
+	nodeID := int64(-1)
+	if n, ok := node.(metadata.Definition); ok {
+		nodeID = n.ID()
+	}
+	return &UnsupportedError{
+		NodeID: nodeID,
+		Msg:    fmt.Sprintf(format, a...),
+	}
+}
+
+// Error returns an error message describing the unsupported feature, prefixed
+// by its location.
+func (e *UnsupportedError) Error() string {
+	var loc []string
+	if len(e.FuncName) > 0 {
+		loc = append(loc, fmt.Sprintf("function %q", e.FuncName))
+	}
+	if len(e.VarName) > 0 {
+		loc = append(loc, fmt.Sprintf("variable %q", e.VarName))
+	}
+	if e.NodeID != -1 {
+		loc = append(loc, fmt.Sprintf("metadata node !%d", e.NodeID))
+	}
+	if len(loc) == 0 {
+		return e.Msg
+	}
+	return fmt.Sprintf("%s: %s", strings.Join(loc, ", "), e.Msg)
+}
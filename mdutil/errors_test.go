@@ -0,0 +1,60 @@
+package mdutil
+
+import (
+	"testing"
+
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/metadata"
+)
+
+func TestUnsupportedError(t *testing.T) {
+	golden := []struct {
+		err  *UnsupportedError
+		want string
+	}{
+		{
+			err:  &UnsupportedError{NodeID: -1, Msg: "unsupported"},
+			want: "unsupported",
+		},
+		{
+			err:  &UnsupportedError{FuncName: "f", NodeID: -1, Msg: "unsupported"},
+			want: `function "f": unsupported`,
+		},
+		{
+			err:  &UnsupportedError{FuncName: "f", VarName: "x", NodeID: 7, Msg: "unsupported"},
+			want: `function "f", variable "x", metadata node !7: unsupported`,
+		},
+	}
+	for _, g := range golden {
+		if got := g.err.Error(); got != g.want {
+			t.Errorf("error message mismatch; expected %q, got %q", g.want, got)
+		}
+	}
+}
+
+func TestTypeFromFieldUnsupported(t *testing.T) {
+	golden := []struct {
+		name string
+		typ  metadata.Field
+	}{
+		{
+			name: "unknown basic type",
+			typ:  &metadata.DIBasicType{MetadataID: 3, Tag: enum.DwarfTagBaseType, Name: "_Float16", Size: 16, Encoding: enum.DwarfAttEncodingFloat},
+		},
+		{
+			name: "unsupported type",
+			typ:  &metadata.DIFile{MetadataID: 3, Filename: "a.c"},
+		},
+	}
+	for _, g := range golden {
+		_, err := TypeFromField(g.typ)
+		e, ok := err.(*UnsupportedError)
+		if !ok {
+			t.Errorf("%s: expected *UnsupportedError, got %T (%v)", g.name, err, err)
+			continue
+		}
+		if e.NodeID != 3 {
+			t.Errorf("%s: metadata node ID mismatch; expected 3, got %d", g.name, e.NodeID)
+		}
+	}
+}
@@ -1,3 +0,0 @@
-#!/bin/bash
-
-string2enum -type BasicType -pkg mdutil -linecomment ../ctype
@@ -2,12 +2,70 @@
 package mdutil
 
 import (
+	"io/ioutil"
+	"regexp"
+	"strconv"
+
+	"github.com/llir/llvm/asm"
 	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/enum"
 	"github.com/llir/llvm/ir/metadata"
 	"github.com/llir/llvm/ir/value"
 	"github.com/mewmew/genie/ctype"
+	"github.com/pkg/errors"
 )
 
+// dwarfCCNames maps from the names of DWARF calling conventions of LLVM not
+// recognized by the LLVM IR parser to their values.
+var dwarfCCNames = map[string]enum.DwarfCC{
+	"DW_CC_LLVM_Win64":        dwarfCCLLVMWin64,
+	"DW_CC_LLVM_X86_64SysV":   dwarfCCLLVMX86_64SysV,
+	"DW_CC_LLVM_AAPCS":        dwarfCCLLVMAAPCS,
+	"DW_CC_LLVM_AAPCS_VFP":    dwarfCCLLVMAAPCS_VFP,
+	"DW_CC_LLVM_IntelOclBicc": dwarfCCLLVMIntelOclBicc,
+	"DW_CC_LLVM_SpirFunction": dwarfCCLLVMSpirFunction,
+	"DW_CC_LLVM_OpenCLKernel": dwarfCCLLVMOpenCLKernel,
+	"DW_CC_LLVM_Swift":        dwarfCCLLVMSwift,
+	"DW_CC_LLVM_PreserveMost": dwarfCCLLVMPreserveMost,
+	"DW_CC_LLVM_PreserveAll":  dwarfCCLLVMPreserveAll,
+	"DW_CC_LLVM_X86RegCall":   dwarfCCLLVMX86RegCall,
+}
+
+// dwarfCCRegexp matches the cc field of DISubroutineType metadata nodes; the
+// node up to the field value (submatch 1) and the name of the LLVM DWARF
+// calling convention (submatch 2).
+var dwarfCCRegexp = regexp.MustCompile(`(!DISubroutineType\([^)]*\bcc: )(DW_CC_LLVM_\w+)`)
+
+// replaceDwarfCCs substitutes the names of DWARF calling conventions not
+// recognized by the LLVM IR parser with their numeric values, in the cc fields
+// of DISubroutineType metadata nodes of the given LLVM IR assembly.
+func replaceDwarfCCs(src string) string {
+	return dwarfCCRegexp.ReplaceAllStringFunc(src, func(s string) string {
+		m := dwarfCCRegexp.FindStringSubmatch(s)
+		cc, ok := dwarfCCNames[m[2]]
+		if !ok {
+			return s
+		}
+		return m[1] + strconv.FormatInt(int64(cc), 10)
+	})
+}
+
+// ParseFile parses the given LLVM IR assembly file. The names of DWARF calling
+// conventions not recognized by the LLVM IR parser (e.g. DW_CC_LLVM_Win64 of
+// functions declared with __attribute__((ms_abi))) are substituted with their
+// numeric values.
+func ParseFile(path string) (*ir.Module, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	m, err := asm.ParseString(path, replaceDwarfCCs(string(buf)))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return m, nil
+}
+
 // Var maps an LLVM IR local variable to its corresponding C variable and type
 // information, as based on metadata.
 type Var struct {
@@ -21,8 +79,9 @@ type Var struct {
 
 // LocalVars returns the mapping between LLVM IR local variables and their
 // corresponding C variables and type information, as based on the metadata of
-// the given function.
-func LocalVars(f *ir.Func) []Var {
+// the given function. An *UnsupportedError is returned for metadata not yet
+// supported.
+func LocalVars(f *ir.Func) ([]Var, error) {
 	var locals []Var
 	for _, block := range f.Blocks {
 		for _, inst := range block.Insts {
@@ -59,7 +118,14 @@ func LocalVars(f *ir.Func) []Var {
 			}
 			cVarName := diVar.Name
 			// Locate C type information.
-			cType := TypeFromField(diVar.Type)
+			cType, err := TypeFromField(diVar.Type)
+			if err != nil {
+				if e, ok := err.(*UnsupportedError); ok {
+					e.FuncName = f.Name()
+					e.VarName = cVarName
+				}
+				return nil, err
+			}
 			// Record local variable.
 			local := Var{
 				LLVarName: llVarName,
@@ -69,5 +135,5 @@ func LocalVars(f *ir.Func) []Var {
 			locals = append(locals, local)
 		}
 	}
-	return locals
+	return locals, nil
 }
@@ -0,0 +1,109 @@
+package mdutil
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/llir/llvm/ir/metadata"
+	"github.com/mewmew/genie/ctype"
+)
+
+func TestReplaceDwarfCCs(t *testing.T) {
+	golden := []struct {
+		src  string
+		want string
+	}{
+		{
+			src:  "!4 = !DISubroutineType(cc: DW_CC_LLVM_Win64, types: !3)",
+			want: "!4 = !DISubroutineType(cc: 193, types: !3)",
+		},
+		{
+			src:  "!4 = !DISubroutineType(flags: DIFlagPrototyped, cc: DW_CC_LLVM_X86RegCall, types: !3)",
+			want: "!4 = !DISubroutineType(flags: DIFlagPrototyped, cc: 203, types: !3)",
+		},
+		// Calling conventions recognized by the LLVM IR parser.
+		{
+			src:  "!4 = !DISubroutineType(cc: DW_CC_LLVM_vectorcall, types: !3)",
+			want: "!4 = !DISubroutineType(cc: DW_CC_LLVM_vectorcall, types: !3)",
+		},
+		// Identifiers, strings and comments.
+		{
+			src:  "declare void @DW_CC_LLVM_Swifty()",
+			want: "declare void @DW_CC_LLVM_Swifty()",
+		},
+		{
+			src:  `@s = constant [16 x i8] c"DW_CC_LLVM_Win64"`,
+			want: `@s = constant [16 x i8] c"DW_CC_LLVM_Win64"`,
+		},
+		{
+			src:  "; cc: DW_CC_LLVM_Win64",
+			want: "; cc: DW_CC_LLVM_Win64",
+		},
+	}
+	for _, g := range golden {
+		if got := replaceDwarfCCs(g.src); got != g.want {
+			t.Errorf("%q: replacement mismatch; expected %q, got %q", g.src, g.want, got)
+		}
+	}
+}
+
+func TestParseFileDwarfCC(t *testing.T) {
+	const src = `
+define void @f() !dbg !5 {
+	ret void
+}
+
+define void @g() !dbg !7 {
+	ret void
+}
+
+!llvm.module.flags = !{!0}
+
+!0 = !{i32 2, !"Debug Info Version", i32 3}
+!1 = !DIFile(filename: "a.c", directory: "/")
+!2 = distinct !DICompileUnit(language: DW_LANG_C99, file: !1, emissionKind: FullDebug)
+!3 = !{null}
+!4 = !DISubroutineType(cc: DW_CC_LLVM_Win64, types: !3)
+!5 = distinct !DISubprogram(name: "f", scope: !1, file: !1, line: 1, type: !4, unit: !2)
+!6 = !DISubroutineType(cc: DW_CC_LLVM_X86_64SysV, types: !3)
+!7 = distinct !DISubprogram(name: "g", scope: !1, file: !1, line: 2, type: !6, unit: !2)
+`
+	llPath := filepath.Join(t.TempDir(), "a.ll")
+	if err := ioutil.WriteFile(llPath, []byte(src), 0644); err != nil {
+		t.Fatalf("unable to write LLVM IR file; %v", err)
+	}
+	m, err := ParseFile(llPath)
+	if err != nil {
+		t.Fatalf("unable to parse LLVM IR file; %v", err)
+	}
+	want := map[string]ctype.CallingConv{
+		"f": ctype.CallConvMSABI,
+		"g": ctype.CallConvSysVABI,
+	}
+	for _, f := range m.Funcs {
+		var diSub *metadata.DISubprogram
+		for _, md := range f.MDAttachments() {
+			if n, ok := md.Node.(*metadata.DISubprogram); ok {
+				diSub = n
+			}
+		}
+		if diSub == nil {
+			t.Errorf("unable to locate debug information of function %q", f.Name())
+			continue
+		}
+		typ, err := TypeFromField(diSub.Type)
+		if err != nil {
+			t.Errorf("unable to translate type of function %q; %v", f.Name(), err)
+			continue
+		}
+		funcType, ok := typ.(*ctype.FuncType)
+		if !ok {
+			t.Errorf("type mismatch of function %q; expected *ctype.FuncType, got %T", f.Name(), typ)
+			continue
+		}
+		if funcType.CallConv != want[f.Name()] {
+			t.Errorf("calling convention mismatch of function %q; expected %v, got %v", f.Name(), want[f.Name()], funcType.CallConv)
+		}
+	}
+}
@@ -1,134 +1,439 @@
 package mdutil
 
 import (
-	"fmt"
-
 	"github.com/llir/llvm/ir/enum"
 	"github.com/llir/llvm/ir/metadata"
 	"github.com/mewmew/genie/ctype"
+	"github.com/pkg/errors"
 )
 
 // TypeFromField returns the C type corresponding to the given LLVM IR metadata
+// type. An *UnsupportedError is returned for metadata not yet supported.
+func TypeFromField(t metadata.Field) (ctype.Type, error) {
+	gen := newTypeGen()
+	return gen.typeFromField(t)
+}
+
+// typeGen tracks the C types translated from LLVM IR metadata types, so that
+// self-referential composite types (e.g. linked lists) may be resolved.
+type typeGen struct {
+	// Maps from LLVM IR metadata composite type to the corresponding C type.
+	composites map[*metadata.DICompositeType]ctype.Type
+}
+
+// newTypeGen returns a new generator of C types from LLVM IR metadata types.
+func newTypeGen() *typeGen {
+	return &typeGen{
+		composites: make(map[*metadata.DICompositeType]ctype.Type),
+	}
+}
+
+// typeFromField returns the C type corresponding to the given LLVM IR metadata
 // type.
-func TypeFromField(t metadata.Field) ctype.Type {
+func (gen *typeGen) typeFromField(t metadata.Field) (ctype.Type, error) {
 	switch t := t.(type) {
 	case *metadata.DIBasicType:
 		return typeFromDIBasicType(t)
 	case *metadata.DICompositeType:
-		return typeFromDICompositeType(t)
+		return gen.typeFromDICompositeType(t)
 	case *metadata.DIDerivedType:
-		return typeFromDIDerivedType(t)
+		return gen.typeFromDIDerivedType(t)
 	case *metadata.DISubroutineType:
-		return typeFromDISubroutineType(t)
+		return gen.typeFromDISubroutineType(t)
 	case *metadata.NullLit:
-		return ctype.BasicTypeVoid
+		return ctype.BasicTypeVoid, nil
 	default:
-		panic(fmt.Errorf("support for type %T not yet implemented", t))
+		return nil, unsupported(t, "support for type %T not yet implemented", t)
 	}
 }
 
 // typeFromDIBasicType returns the C type corresponding to the given LLVM IR
 // metadata derived type.
-func typeFromDIBasicType(t *metadata.DIBasicType) ctype.Type {
-	name := canonBasicTypeString(t.Name)
-	return BasicTypeFromString(name)
-}
-
-// canonBasicTypeString returns the canonical basic type string.
-func canonBasicTypeString(name string) string {
-	// "the type specifiers may occur in any order, possibly intermixed with the
-	// other declaration specifiers."
-	//
-	// ref: https://stackoverflow.com/a/45159300
-	switch name {
-	case "long unsigned int":
-		return "unsigned long int"
+func typeFromDIBasicType(t *metadata.DIBasicType) (ctype.Type, error) {
+	name := ctype.CanonBasicTypeString(t.Name)
+	if typ, ok := basicTypeFromName(name); ok {
+		return typ, nil
+	}
+	// Fall back to the DWARF type encoding and size for type names not known
+	// to be C basic types (e.g. "__int64" and "BOOL" of MSVC-style names).
+	if typ, ok := typeFromEncoding(t.Encoding, t.Size); ok {
+		return typ, nil
+	}
+	return nil, unsupported(t, "support for basic type %q (encoding %v, size %d) not yet implemented", t.Name, t.Encoding, t.Size)
+}
+
+// BasicTypeFromString returns the C basic type with the given name, in which
+// type specifiers may be given in any order (e.g. "long unsigned int").
+func BasicTypeFromString(s string) (ctype.BasicType, error) {
+	if t, ok := basicTypeFromName(ctype.CanonBasicTypeString(s)); ok {
+		return t, nil
+	}
+	return 0, errors.Errorf("unable to locate C basic type corresponding to %q", s)
+}
+
+// basicTypeFromName returns the C basic type with the given canonical name, and
+// a boolean indicating if such a basic type was located.
+func basicTypeFromName(name string) (ctype.BasicType, bool) {
+	for t := ctype.BasicTypeVoid; t <= ctype.BasicTypeUInt128; t++ {
+		if t.String() == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// typeFromEncoding returns the C type of the given DWARF type encoding and size
+// in number of bits, and a boolean indicating if such a type was located.
+// Sized integer types are mapped to the C basic type of the same size on ILP32,
+// LLP64 and LP64 targets alike.
+func typeFromEncoding(encoding enum.DwarfAttEncoding, size uint64) (ctype.Type, bool) {
+	switch encoding {
+	case enum.DwarfAttEncodingAddress:
+		return &ctype.PointerType{Elem: ctype.BasicTypeVoid}, true
+	case enum.DwarfAttEncodingBoolean:
+		// Booleans wider than _Bool are stored as integers (e.g. Win32 BOOL is
+		// a type definition of int).
+		if size == 8 {
+			return ctype.BasicTypeBool, true
+		}
+		return sizedInt(size, true)
+	case enum.DwarfAttEncodingSigned:
+		return sizedInt(size, true)
+	case enum.DwarfAttEncodingUnsigned:
+		return sizedInt(size, false)
+	case enum.DwarfAttEncodingSignedChar:
+		if size == 8 {
+			return ctype.BasicTypeChar, true
+		}
+		return sizedInt(size, true)
+	case enum.DwarfAttEncodingUnsignedChar:
+		if size == 8 {
+			return ctype.BasicTypeUChar, true
+		}
+		return sizedInt(size, false)
+	case enum.DwarfAttEncodingUTF:
+		switch size {
+		case 8:
+			return ctype.BasicTypeUChar, true
+		case 16:
+			return ctype.BasicTypeChar16, true
+		case 32:
+			return ctype.BasicTypeChar32, true
+		}
+	case enum.DwarfAttEncodingFloat:
+		switch size {
+		case 32:
+			return ctype.BasicTypeFloat, true
+		case 64:
+			return ctype.BasicTypeDouble, true
+		case 80, 96, 128:
+			return ctype.BasicTypeLongDouble, true
+		}
+	case enum.DwarfAttEncodingComplexFloat:
+		// Size of complex types covers both the real and imaginary parts.
+		switch size {
+		case 64:
+			return ctype.BasicTypeFloatComplex, true
+		case 128:
+			return ctype.BasicTypeDoubleComplex, true
+		case 160, 192, 256:
+			return ctype.BasicTypeLongDoubleComplex, true
+		}
+	}
+	return nil, false
+}
+
+// sizedInt returns the C integer type of the given size in number of bits and
+// signedness, and a boolean indicating if such a type was located.
+func sizedInt(size uint64, signed bool) (ctype.Type, bool) {
+	var s, u ctype.BasicType
+	switch size {
+	case 8:
+		s, u = ctype.BasicTypeSChar, ctype.BasicTypeUChar
+	case 16:
+		s, u = ctype.BasicTypeShortInt, ctype.BasicTypeUShortInt
+	case 32:
+		s, u = ctype.BasicTypeInt, ctype.BasicTypeUInt
+	case 64:
+		s, u = ctype.BasicTypeLongLongInt, ctype.BasicTypeULongLongInt
+	case 128:
+		s, u = ctype.BasicTypeInt128, ctype.BasicTypeUInt128
 	default:
-		return name
+		return nil, false
 	}
+	if signed {
+		return s, true
+	}
+	return u, true
 }
 
 // typeFromDICompositeType returns the C type corresponding to the given LLVM IR
 // metadata composite type.
-func typeFromDICompositeType(t *metadata.DICompositeType) ctype.Type {
+func (gen *typeGen) typeFromDICompositeType(t *metadata.DICompositeType) (ctype.Type, error) {
+	if typ, ok := gen.composites[t]; ok {
+		return typ, nil
+	}
 	switch t.Tag {
+	case enum.DwarfTagArrayType:
+		return gen.typeFromDIArrayType(t)
 	case enum.DwarfTagEnumerationType:
-		return typeFromDIEnumType(t)
+		return gen.typeFromDIEnumType(t), nil
 	case enum.DwarfTagStructureType:
-		return typeFromDIStructType(t)
+		return gen.typeFromDIStructType(t)
+	case enum.DwarfTagUnionType:
+		return gen.typeFromDIUnionType(t)
 	default:
-		panic(fmt.Errorf("support for tag %v not yet implemented", t.Tag))
+		return nil, unsupported(t, "support for tag %v not yet implemented", t.Tag)
+	}
+}
+
+// typeFromDIArrayType returns the C type corresponding to the given LLVM IR
+// metadata array type.
+func (gen *typeGen) typeFromDIArrayType(t *metadata.DICompositeType) (ctype.Type, error) {
+	typ, err := gen.typeFromField(t.BaseType)
+	if err != nil {
+		return nil, err
+	}
+	if t.Elements == nil {
+		return &ctype.ArrayType{Elem: typ, Len: -1}, nil
+	}
+	// Each DISubrange element specifies one dimension, outermost first; build
+	// arrays of arrays from the innermost dimension and out.
+	subranges := t.Elements.Fields
+	for i := len(subranges) - 1; i >= 0; i-- {
+		subrange, ok := subranges[i].(*metadata.DISubrange)
+		if !ok {
+			continue
+		}
+		typ = &ctype.ArrayType{
+			Elem: typ,
+			Len:  subrangeLen(subrange),
+		}
 	}
+	return typ, nil
+}
+
+// subrangeLen returns the number of elements of the given LLVM IR metadata
+// subrange; or -1 if unknown (e.g. flexible array members and variable length
+// arrays).
+func subrangeLen(subrange *metadata.DISubrange) int64 {
+	count, ok := subrange.Count.(metadata.IntLit)
+	if !ok || count < 0 {
+		return -1
+	}
+	return int64(count)
 }
 
 // typeFromDIEnumType returns the C type corresponding to the given LLVM IR
 // metadata enumerate type.
-func typeFromDIEnumType(t *metadata.DICompositeType) ctype.Type {
-	return &ctype.EnumType{
+func (gen *typeGen) typeFromDIEnumType(t *metadata.DICompositeType) ctype.Type {
+	typ := &ctype.EnumType{
 		Name: t.Name,
 	}
+	gen.composites[t] = typ
+	// Elements are not present for forward declared enums.
+	if t.Elements != nil {
+		for _, elem := range t.Elements.Fields {
+			e, ok := elem.(*metadata.DIEnumerator)
+			if !ok {
+				continue
+			}
+			enumerator := &ctype.Enumerator{
+				Name:  e.Name,
+				Value: e.Value,
+			}
+			typ.Enumerators = append(typ.Enumerators, enumerator)
+		}
+	}
+	typ.Flags = ctype.IsFlagEnum(typ.Enumerators)
+	return typ
 }
 
 // typeFromDIStructType returns the C type corresponding to the given LLVM IR
 // metadata structure type.
-func typeFromDIStructType(t *metadata.DICompositeType) ctype.Type {
-	return &ctype.StructType{
+func (gen *typeGen) typeFromDIStructType(t *metadata.DICompositeType) (ctype.Type, error) {
+	typ := &ctype.StructType{
+		Name: t.Name,
+	}
+	// Record struct type before translating its fields, as fields may refer
+	// back to the struct type (e.g. `struct node *next`).
+	gen.composites[t] = typ
+	fields, err := gen.fieldsFromElements(t.Elements)
+	if err != nil {
+		return nil, err
+	}
+	typ.Fields = fields
+	return typ, nil
+}
+
+// typeFromDIUnionType returns the C type corresponding to the given LLVM IR
+// metadata union type.
+func (gen *typeGen) typeFromDIUnionType(t *metadata.DICompositeType) (ctype.Type, error) {
+	typ := &ctype.UnionType{
 		Name: t.Name,
 	}
+	// Record union type before translating its fields, as fields may refer back
+	// to the union type.
+	gen.composites[t] = typ
+	fields, err := gen.fieldsFromElements(t.Elements)
+	if err != nil {
+		return nil, err
+	}
+	typ.Fields = fields
+	return typ, nil
+}
+
+// fieldsFromElements returns the C structure or union fields corresponding to the
+// DW_TAG_member elements of the given LLVM IR metadata composite type.
+func (gen *typeGen) fieldsFromElements(elems *metadata.Tuple) ([]*ctype.Field, error) {
+	// Elements are not present for forward declared (opaque) types.
+	if elems == nil {
+		return nil, nil
+	}
+	var fields []*ctype.Field
+	for _, elem := range elems.Fields {
+		member, ok := elem.(*metadata.DIDerivedType)
+		if !ok || member.Tag != enum.DwarfTagMember {
+			continue
+		}
+		typ, err := gen.typeFromField(member.BaseType)
+		if err != nil {
+			return nil, err
+		}
+		field := &ctype.Field{
+			Name:      member.Name,
+			Typ:       typ,
+			BitOffset: member.Offset,
+			BitSize:   member.Size,
+			BitField:  member.Flags&enum.DIFlagBitField != 0,
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
 }
 
 // typeFromDIDerivedType returns the C type corresponding to the given LLVM IR
 // metadata derived type.
-func typeFromDIDerivedType(t *metadata.DIDerivedType) ctype.Type {
+func (gen *typeGen) typeFromDIDerivedType(t *metadata.DIDerivedType) (ctype.Type, error) {
 	switch t.Tag {
 	case enum.DwarfTagConstType:
-		return typeFromDIConstType(t)
+		return gen.typeFromDIConstType(t)
 	case enum.DwarfTagPointerType:
-		return typeFromDIPointerType(t)
+		return gen.typeFromDIPointerType(t)
 	case enum.DwarfTagTypedef:
-		return typeFromDITypedef(t)
+		return gen.typeFromDITypedef(t)
 	default:
-		panic(fmt.Errorf("support for tag %v not yet implemented", t.Tag))
+		return nil, unsupported(t, "support for tag %v not yet implemented", t.Tag)
 	}
 }
 
 // typeFromDIConstType returns the C type corresponding to the given LLVM IR
 // metadata constant type.
-func typeFromDIConstType(t *metadata.DIDerivedType) ctype.Type {
-	return &ctype.ConstType{
-		Typ: TypeFromField(t.BaseType),
+func (gen *typeGen) typeFromDIConstType(t *metadata.DIDerivedType) (ctype.Type, error) {
+	typ, err := gen.typeFromField(t.BaseType)
+	if err != nil {
+		return nil, err
 	}
+	return &ctype.ConstType{
+		Typ: typ,
+	}, nil
 }
 
 // typeFromDIPointerType returns the C type corresponding to the given LLVM IR
 // metadata pointer type.
-func typeFromDIPointerType(t *metadata.DIDerivedType) ctype.Type {
-	return &ctype.PointerType{
-		Elem: TypeFromField(t.BaseType),
+func (gen *typeGen) typeFromDIPointerType(t *metadata.DIDerivedType) (ctype.Type, error) {
+	elem, err := gen.typeFromField(t.BaseType)
+	if err != nil {
+		return nil, err
 	}
+	return &ctype.PointerType{
+		Elem: elem,
+	}, nil
 }
 
 // typeFromDITypedef returns the C type corresponding to the given LLVM IR
 // metadata type definition.
-func typeFromDITypedef(t *metadata.DIDerivedType) ctype.Type {
+func (gen *typeGen) typeFromDITypedef(t *metadata.DIDerivedType) (ctype.Type, error) {
+	typ, err := gen.typeFromField(t.BaseType)
+	if err != nil {
+		return nil, err
+	}
 	return &ctype.Typedef{
 		Name: t.Name,
-		Typ:  TypeFromField(t.BaseType),
-	}
+		Typ:  typ,
+	}, nil
 }
 
 // typeFromDISubroutineType returns the C type corresponding to the given LLVM
 // IR metadata subroutine type.
-func typeFromDISubroutineType(t *metadata.DISubroutineType) ctype.Type {
-	// TODO: parse t.CC.
+func (gen *typeGen) typeFromDISubroutineType(t *metadata.DISubroutineType) (ctype.Type, error) {
+	callConv, ok := callConvFromDwarfCC(t.CC)
+	if !ok {
+		return nil, unsupported(t, "support for calling convention %v not yet implemented", t.CC)
+	}
+	if t.Types == nil || len(t.Types.Fields) == 0 {
+		return nil, unsupported(t, "missing return type of subroutine type")
+	}
 	var paramTypes []ctype.Type
-	retType := TypeFromField(t.Types.Fields[0])
+	retType, err := gen.typeFromField(t.Types.Fields[0])
+	if err != nil {
+		return nil, err
+	}
 	for _, field := range t.Types.Fields[1:] {
-		paramType := TypeFromField(field)
+		paramType, err := gen.typeFromField(field)
+		if err != nil {
+			return nil, err
+		}
 		paramTypes = append(paramTypes, paramType)
 	}
 	return &ctype.FuncType{
 		RetType:    retType,
+		CallConv:   callConv,
 		ParamTypes: paramTypes,
+	}, nil
+}
+
+// DWARF calling conventions of LLVM not defined by the enum package.
+//
+// ref: llvm/include/llvm/BinaryFormat/Dwarf.def
+const (
+	dwarfCCLLVMWin64        enum.DwarfCC = 0xC1 // DW_CC_LLVM_Win64
+	dwarfCCLLVMX86_64SysV   enum.DwarfCC = 0xC2 // DW_CC_LLVM_X86_64SysV
+	dwarfCCLLVMAAPCS        enum.DwarfCC = 0xC3 // DW_CC_LLVM_AAPCS
+	dwarfCCLLVMAAPCS_VFP    enum.DwarfCC = 0xC4 // DW_CC_LLVM_AAPCS_VFP
+	dwarfCCLLVMIntelOclBicc enum.DwarfCC = 0xC5 // DW_CC_LLVM_IntelOclBicc
+	dwarfCCLLVMSpirFunction enum.DwarfCC = 0xC6 // DW_CC_LLVM_SpirFunction
+	dwarfCCLLVMOpenCLKernel enum.DwarfCC = 0xC7 // DW_CC_LLVM_OpenCLKernel
+	dwarfCCLLVMSwift        enum.DwarfCC = 0xC8 // DW_CC_LLVM_Swift
+	dwarfCCLLVMPreserveMost enum.DwarfCC = 0xC9 // DW_CC_LLVM_PreserveMost
+	dwarfCCLLVMPreserveAll  enum.DwarfCC = 0xCA // DW_CC_LLVM_PreserveAll
+	dwarfCCLLVMX86RegCall   enum.DwarfCC = 0xCB // DW_CC_LLVM_X86RegCall
+)
+
+// callConvFromDwarfCC returns the C calling convention corresponding to the
+// given DWARF calling convention (zero for the default calling convention), and
+// a boolean indicating if the calling convention is supported.
+func callConvFromDwarfCC(cc enum.DwarfCC) (ctype.CallingConv, bool) {
+	switch cc {
+	// DW_CC attribute not present.
+	case 0, enum.DwarfCCNormal:
+		return 0, true
+	case enum.DwarfCCBORLANDStdcall:
+		return ctype.CallConvStdCall, true
+	// Clang emits DW_CC_BORLAND_msfastcall for __fastcall.
+	case enum.DwarfCCBORLANDMSFastcall:
+		return ctype.CallConvFastCall, true
+	case enum.DwarfCCBORLANDThiscall:
+		return ctype.CallConvThisCall, true
+	case enum.DwarfCCLLVMVectorcall:
+		return ctype.CallConvVectorCall, true
+	// Clang emits DW_CC_LLVM_Win64 for __attribute__((ms_abi)) and
+	// DW_CC_LLVM_X86_64SysV for __attribute__((sysv_abi)).
+	case dwarfCCLLVMWin64:
+		return ctype.CallConvMSABI, true
+	case dwarfCCLLVMX86_64SysV:
+		return ctype.CallConvSysVABI, true
+	default:
+		return 0, false
 	}
 }
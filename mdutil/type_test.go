@@ -0,0 +1,363 @@
+package mdutil
+
+import (
+	"testing"
+
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/metadata"
+	"github.com/mewmew/genie/ctype"
+)
+
+func TestTypeFromFieldStruct(t *testing.T) {
+	intType := &metadata.DIBasicType{Tag: enum.DwarfTagBaseType, Name: "int", Size: 32, Encoding: enum.DwarfAttEncodingSigned}
+	// struct node { int val; struct node *next; };
+	node := &metadata.DICompositeType{Tag: enum.DwarfTagStructureType, Name: "node", Size: 128}
+	next := &metadata.DIDerivedType{Tag: enum.DwarfTagPointerType, BaseType: node, Size: 64}
+	node.Elements = &metadata.Tuple{
+		Fields: []metadata.Field{
+			&metadata.DIDerivedType{Tag: enum.DwarfTagMember, Name: "val", BaseType: intType, Size: 32},
+			&metadata.DIDerivedType{Tag: enum.DwarfTagMember, Name: "next", BaseType: next, Size: 64, Offset: 64},
+		},
+	}
+	typ, err := TypeFromField(node)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	st, ok := typ.(*ctype.StructType)
+	if !ok {
+		t.Fatalf("type mismatch; expected *ctype.StructType, got %T", typ)
+	}
+	want := []struct {
+		name      string
+		bitOffset uint64
+		bitSize   uint64
+	}{
+		{name: "val", bitOffset: 0, bitSize: 32},
+		{name: "next", bitOffset: 64, bitSize: 64},
+	}
+	if len(st.Fields) != len(want) {
+		t.Fatalf("number of fields mismatch; expected %d, got %d", len(want), len(st.Fields))
+	}
+	for i, field := range st.Fields {
+		if field.Name != want[i].name || field.BitOffset != want[i].bitOffset || field.BitSize != want[i].bitSize {
+			t.Errorf("field %d mismatch; expected %s (offset %d, size %d), got %s (offset %d, size %d)", i, want[i].name, want[i].bitOffset, want[i].bitSize, field.Name, field.BitOffset, field.BitSize)
+		}
+	}
+	// The self-referential field refers back to the same struct type.
+	if ptr, ok := st.Fields[1].Typ.(*ctype.PointerType); !ok || ptr.Elem != st {
+		t.Errorf("type mismatch of field next; expected pointer to %v, got %v", st, st.Fields[1].Typ)
+	}
+
+	// struct opaque;
+	opaque := &metadata.DICompositeType{Tag: enum.DwarfTagStructureType, Name: "opaque", Flags: enum.DIFlagFwdDecl}
+	typ, err = TypeFromField(opaque)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if st, ok := typ.(*ctype.StructType); !ok || len(st.Fields) != 0 {
+		t.Errorf("type mismatch of forward declared struct; expected struct without fields, got %#v", typ)
+	}
+}
+
+func TestTypeFromFieldEnum(t *testing.T) {
+	// enum color { RED, GREEN = 2, BLUE = -1 };
+	color := &metadata.DICompositeType{
+		Tag:  enum.DwarfTagEnumerationType,
+		Name: "color",
+		Size: 32,
+		Elements: &metadata.Tuple{
+			Fields: []metadata.Field{
+				&metadata.DIEnumerator{Name: "RED", Value: 0},
+				&metadata.DIEnumerator{Name: "GREEN", Value: 2},
+				&metadata.DIEnumerator{Name: "BLUE", Value: -1},
+			},
+		},
+	}
+	typ, err := TypeFromField(color)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	et, ok := typ.(*ctype.EnumType)
+	if !ok {
+		t.Fatalf("type mismatch; expected *ctype.EnumType, got %T", typ)
+	}
+	want := []ctype.Enumerator{
+		{Name: "RED", Value: 0},
+		{Name: "GREEN", Value: 2},
+		{Name: "BLUE", Value: -1},
+	}
+	if len(et.Enumerators) != len(want) {
+		t.Fatalf("number of enumerators mismatch; expected %d, got %d", len(want), len(et.Enumerators))
+	}
+	for i, e := range et.Enumerators {
+		if *e != want[i] {
+			t.Errorf("enumerator %d mismatch; expected %v, got %v", i, want[i], *e)
+		}
+	}
+}
+
+func TestTypeFromFieldArray(t *testing.T) {
+	intType := &metadata.DIBasicType{Tag: enum.DwarfTagBaseType, Name: "int", Size: 32, Encoding: enum.DwarfAttEncodingSigned}
+	golden := []struct {
+		// Array dimensions, outermost first; -1 if unknown.
+		counts []int64
+		want   string
+	}{
+		{counts: []int64{3}, want: "int [3]"},
+		{counts: []int64{2, 3}, want: "int [2][3]"},
+		{counts: []int64{-1}, want: "int []"},
+		{counts: nil, want: "int []"},
+	}
+	for _, g := range golden {
+		arr := &metadata.DICompositeType{Tag: enum.DwarfTagArrayType, BaseType: intType}
+		if g.counts != nil {
+			arr.Elements = &metadata.Tuple{}
+			for _, count := range g.counts {
+				arr.Elements.Fields = append(arr.Elements.Fields, &metadata.DISubrange{Count: metadata.IntLit(count)})
+			}
+		}
+		typ, err := TypeFromField(arr)
+		if err != nil {
+			t.Errorf("%+v", err)
+			continue
+		}
+		if _, ok := typ.(*ctype.ArrayType); !ok {
+			t.Errorf("%q: type mismatch; expected *ctype.ArrayType, got %T", g.want, typ)
+			continue
+		}
+		if got := typ.String(); got != g.want {
+			t.Errorf("array type mismatch; expected %q, got %q", g.want, got)
+		}
+	}
+}
+
+func TestTypeFromFieldUnion(t *testing.T) {
+	intType := &metadata.DIBasicType{Tag: enum.DwarfTagBaseType, Name: "int", Size: 32, Encoding: enum.DwarfAttEncodingSigned}
+	floatType := &metadata.DIBasicType{Tag: enum.DwarfTagBaseType, Name: "float", Size: 32, Encoding: enum.DwarfAttEncodingFloat}
+	// union val { int i; float f; };
+	val := &metadata.DICompositeType{
+		Tag:  enum.DwarfTagUnionType,
+		Name: "val",
+		Size: 32,
+		Elements: &metadata.Tuple{
+			Fields: []metadata.Field{
+				&metadata.DIDerivedType{Tag: enum.DwarfTagMember, Name: "i", BaseType: intType, Size: 32},
+				&metadata.DIDerivedType{Tag: enum.DwarfTagMember, Name: "f", BaseType: floatType, Size: 32},
+			},
+		},
+	}
+	typ, err := TypeFromField(val)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	ut, ok := typ.(*ctype.UnionType)
+	if !ok {
+		t.Fatalf("type mismatch; expected *ctype.UnionType, got %T", typ)
+	}
+	if ut.Name != "val" || len(ut.Fields) != 2 {
+		t.Fatalf("union mismatch; expected union val with 2 fields, got union %s with %d fields", ut.Name, len(ut.Fields))
+	}
+	want := []ctype.Type{ctype.BasicTypeInt, ctype.BasicTypeFloat}
+	for i, field := range ut.Fields {
+		if field.BitOffset != 0 || field.Typ != want[i] {
+			t.Errorf("field %s mismatch; expected %v at offset 0, got %v at offset %d", field.Name, want[i], field.Typ, field.BitOffset)
+		}
+	}
+}
+
+func TestTypeFromFieldBasic(t *testing.T) {
+	golden := []struct {
+		name string
+		want ctype.BasicType
+	}{
+		{name: "char", want: ctype.BasicTypeChar},
+		{name: "long unsigned int", want: ctype.BasicTypeULongInt},
+		{name: "long long unsigned int", want: ctype.BasicTypeULongLongInt},
+		{name: "_Bool", want: ctype.BasicTypeBool},
+		{name: "wchar_t", want: ctype.BasicTypeWChar},
+		{name: "char16_t", want: ctype.BasicTypeChar16},
+		{name: "__int128", want: ctype.BasicTypeInt128},
+		{name: "unsigned __int128", want: ctype.BasicTypeUInt128},
+		{name: "complex float", want: ctype.BasicTypeFloatComplex},
+	}
+	for _, g := range golden {
+		typ, err := TypeFromField(&metadata.DIBasicType{Tag: enum.DwarfTagBaseType, Name: g.name})
+		if err != nil {
+			t.Errorf("%+v", err)
+			continue
+		}
+		if typ != g.want {
+			t.Errorf("%q: basic type mismatch; expected %v, got %v", g.name, g.want, typ)
+		}
+	}
+}
+
+func TestTypeFromEncoding(t *testing.T) {
+	golden := []struct {
+		name     string
+		encoding enum.DwarfAttEncoding
+		size     uint64
+		want     string
+	}{
+		{name: "__int64", encoding: enum.DwarfAttEncodingSigned, size: 64, want: "long long int"},
+		{name: "DWORD", encoding: enum.DwarfAttEncodingUnsigned, size: 32, want: "unsigned int"},
+		{name: "WORD", encoding: enum.DwarfAttEncodingUnsigned, size: 16, want: "unsigned short int"},
+		{name: "BOOL", encoding: enum.DwarfAttEncodingBoolean, size: 32, want: "int"},
+		{name: "BOOLEAN", encoding: enum.DwarfAttEncodingBoolean, size: 8, want: "_Bool"},
+		{name: "CHAR", encoding: enum.DwarfAttEncodingSignedChar, size: 8, want: "char"},
+		{name: "BYTE", encoding: enum.DwarfAttEncodingUnsignedChar, size: 8, want: "unsigned char"},
+		{name: "char8", encoding: enum.DwarfAttEncodingUTF, size: 8, want: "unsigned char"},
+		{name: "char32", encoding: enum.DwarfAttEncodingUTF, size: 32, want: "char32_t"},
+		{name: "float80", encoding: enum.DwarfAttEncodingFloat, size: 80, want: "long double"},
+		{name: "cfloat", encoding: enum.DwarfAttEncodingComplexFloat, size: 64, want: "float _Complex"},
+		{name: "ptr", encoding: enum.DwarfAttEncodingAddress, size: 64, want: "void *"},
+		{name: "int128_t", encoding: enum.DwarfAttEncodingSigned, size: 128, want: "__int128"},
+	}
+	for _, g := range golden {
+		typ, err := TypeFromField(&metadata.DIBasicType{Tag: enum.DwarfTagBaseType, Name: g.name, Size: g.size, Encoding: g.encoding})
+		if err != nil {
+			t.Errorf("%+v", err)
+			continue
+		}
+		if got := typ.String(); got != g.want {
+			t.Errorf("%q: type mismatch; expected %q, got %q", g.name, g.want, got)
+		}
+	}
+}
+
+func TestTypeFromEncodingUnsupported(t *testing.T) {
+	golden := []struct {
+		encoding enum.DwarfAttEncoding
+		size     uint64
+	}{
+		{encoding: enum.DwarfAttEncodingSigned, size: 24},
+		{encoding: enum.DwarfAttEncodingFloat, size: 16},
+		{encoding: enum.DwarfAttEncodingUTF, size: 64},
+	}
+	for _, g := range golden {
+		if typ, ok := typeFromEncoding(g.encoding, g.size); ok {
+			t.Errorf("encoding %v, size %d: expected no type, got %q", g.encoding, g.size, typ.String())
+		}
+	}
+}
+
+func TestBasicTypeFromString(t *testing.T) {
+	golden := []struct {
+		s    string
+		want ctype.BasicType
+		ok   bool
+	}{
+		{s: "int", want: ctype.BasicTypeInt, ok: true},
+		{s: "long unsigned int", want: ctype.BasicTypeULongInt, ok: true},
+		{s: "short unsigned int", want: ctype.BasicTypeUShortInt, ok: true},
+		{s: "long long unsigned int", want: ctype.BasicTypeULongLongInt, ok: true},
+		{s: "_Bool", want: ctype.BasicTypeBool, ok: true},
+		{s: "unsigned __int128", want: ctype.BasicTypeUInt128, ok: true},
+		{s: "char16_t", want: ctype.BasicTypeChar16, ok: true},
+		{s: "__int64", ok: false},
+		{s: "", ok: false},
+	}
+	for _, g := range golden {
+		got, err := BasicTypeFromString(g.s)
+		if (err == nil) != g.ok {
+			t.Errorf("%q: error mismatch; expected ok %v, got error %v", g.s, g.ok, err)
+			continue
+		}
+		if got != g.want {
+			t.Errorf("%q: basic type mismatch; expected %v, got %v", g.s, g.want, got)
+		}
+	}
+}
+
+func TestCallConvFromDwarfCC(t *testing.T) {
+	golden := []struct {
+		cc   enum.DwarfCC
+		want ctype.CallingConv
+		ok   bool
+	}{
+		{cc: 0, want: 0, ok: true},
+		{cc: enum.DwarfCCNormal, want: 0, ok: true},
+		{cc: enum.DwarfCCBORLANDStdcall, want: ctype.CallConvStdCall, ok: true},
+		{cc: enum.DwarfCCBORLANDMSFastcall, want: ctype.CallConvFastCall, ok: true},
+		{cc: enum.DwarfCCBORLANDThiscall, want: ctype.CallConvThisCall, ok: true},
+		{cc: enum.DwarfCCLLVMVectorcall, want: ctype.CallConvVectorCall, ok: true},
+		{cc: dwarfCCLLVMWin64, want: ctype.CallConvMSABI, ok: true},
+		{cc: dwarfCCLLVMX86_64SysV, want: ctype.CallConvSysVABI, ok: true},
+		{cc: enum.DwarfCCBORLANDPascal, ok: false},
+		{cc: dwarfCCLLVMX86RegCall, ok: false},
+	}
+	for _, g := range golden {
+		got, ok := callConvFromDwarfCC(g.cc)
+		if got != g.want || ok != g.ok {
+			t.Errorf("calling convention mismatch of 0x%X; expected (%v, %v), got (%v, %v)", int64(g.cc), g.want, g.ok, got, ok)
+		}
+	}
+}
+
+func TestTypeFromFieldSubroutine(t *testing.T) {
+	intType := &metadata.DIBasicType{Tag: enum.DwarfTagBaseType, Name: "int", Size: 32, Encoding: enum.DwarfAttEncodingSigned}
+	golden := []struct {
+		cc   enum.DwarfCC
+		want ctype.CallingConv
+	}{
+		{cc: 0, want: 0},
+		{cc: enum.DwarfCCBORLANDStdcall, want: ctype.CallConvStdCall},
+		{cc: enum.DwarfCCBORLANDMSFastcall, want: ctype.CallConvFastCall},
+	}
+	for _, g := range golden {
+		sub := &metadata.DISubroutineType{
+			CC:    g.cc,
+			Types: &metadata.Tuple{Fields: []metadata.Field{intType, intType}},
+		}
+		typ, err := TypeFromField(sub)
+		if err != nil {
+			t.Errorf("%+v", err)
+			continue
+		}
+		f, ok := typ.(*ctype.FuncType)
+		if !ok {
+			t.Errorf("type mismatch; expected *ctype.FuncType, got %T", typ)
+			continue
+		}
+		if f.CallConv != g.want {
+			t.Errorf("calling convention mismatch of 0x%X; expected %v, got %v", int64(g.cc), g.want, f.CallConv)
+		}
+	}
+	// Unsupported calling conventions are reported.
+	sub := &metadata.DISubroutineType{
+		CC:    enum.DwarfCCBORLANDPascal,
+		Types: &metadata.Tuple{Fields: []metadata.Field{intType}},
+	}
+	if _, err := TypeFromField(sub); err == nil {
+		t.Errorf("expected error for unsupported calling convention")
+	}
+}
+
+func TestTypeFromFieldBitField(t *testing.T) {
+	uintType := &metadata.DIBasicType{Tag: enum.DwarfTagBaseType, Name: "unsigned int", Size: 32, Encoding: enum.DwarfAttEncodingUnsigned}
+	// struct flags { unsigned int a : 3; unsigned int b : 5; unsigned int c; };
+	flags := &metadata.DICompositeType{
+		Tag:  enum.DwarfTagStructureType,
+		Name: "flags",
+		Size: 64,
+		Elements: &metadata.Tuple{
+			Fields: []metadata.Field{
+				&metadata.DIDerivedType{Tag: enum.DwarfTagMember, Name: "a", BaseType: uintType, Size: 3, Flags: enum.DIFlagBitField},
+				&metadata.DIDerivedType{Tag: enum.DwarfTagMember, Name: "b", BaseType: uintType, Size: 5, Offset: 3, Flags: enum.DIFlagBitField},
+				&metadata.DIDerivedType{Tag: enum.DwarfTagMember, Name: "c", BaseType: uintType, Size: 32, Offset: 32},
+			},
+		},
+	}
+	typ, err := TypeFromField(flags)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	st, ok := typ.(*ctype.StructType)
+	if !ok {
+		t.Fatalf("type mismatch; expected *ctype.StructType, got %T", typ)
+	}
+	want := "struct flags {\n\tunsigned int a : 3;\n\tunsigned int b : 5;\n\tunsigned int c;\n};"
+	if got := st.CString(); got != want {
+		t.Errorf("definition mismatch; expected %q, got %q", want, got)
+	}
+}